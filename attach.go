@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sst/opencode-sdk-go"
+	"github.com/sst/opencode-sdk-go/option"
+	"github.com/thomasgormley/chisel/internal/agent"
+	"github.com/thomasgormley/chisel/internal/print"
+)
+
+// runAttach streams the output of a session that is already running, without
+// sending any prompts. Interrupting detaches and leaves the session running.
+func runAttach(ctx context.Context, args []string) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	flagSet := flag.NewFlagSet("chisel attach", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: chisel attach [flags] <session-id>\n")
+		flagSet.PrintDefaults()
+	}
+	flags := cliFlags{
+		flagSet: flagSet,
+	}
+	serverFlags(flagSet, &flags)
+	flagSet.StringVar(&flags.record, "record", "", "write every server event to `file` as JSON lines for chisel replay")
+	flagSet.Parse(args)
+
+	if flagSet.NArg() < 1 {
+		flagSet.Usage()
+		return nil
+	}
+
+	client := opencode.NewClient(option.WithBaseURL(flags.BaseURL()))
+	session, err := client.Session.Get(ctx, flagSet.Arg(0), opencode.SessionGetParams{})
+	if err != nil {
+		return fmt.Errorf("attaching to session %s: %w", flagSet.Arg(0), err)
+	}
+
+	listenOpts, closeRecording, err := listenOptions(client, flags, nil)
+	if err != nil {
+		return err
+	}
+	defer closeRecording()
+	// Attach only watches: permission requests belong to the process driving
+	// the session, so they are shown but not answered.
+	listenOpts = append(listenOpts, agent.WithHandler(agent.NewHandler(os.Stdout)))
+
+	print.Info(os.Stdout, "Attached to session", session.ID, fmt.Sprintf("(%s).", session.Title), "Press Ctrl+C to detach.")
+	err = agent.ListenForEvents(ctx, client, session.ID, listenOpts...)
+	if ctx.Err() != nil {
+		print.Info(os.Stdout, print.Wrap("Detached from session", session.ID))
+		return nil
+	}
+	return err
+}
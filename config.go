@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/thomasgormley/chisel/internal/print"
+)
+
+// runConfig handles "chisel config show [flags] [file]", which prints the
+// effective configuration for file, or the current directory, with any flags
+// applied and the sources it was read from.
+func runConfig(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		fmt.Fprintf(os.Stderr, "usage: chisel config show [flags] [file]\n")
+		return nil
+	}
+
+	flags, err := loadFlags("chisel config show", "[flags] [file]", args[1:])
+	if err != nil {
+		flags.flagSet.Usage()
+		return err
+	}
+
+	cfg := flags.config
+	if len(cfg.Sources) == 0 {
+		print.Info(os.Stdout, "# Sources: built-in defaults only")
+	} else {
+		print.Info(os.Stdout, "# Sources (lowest precedence first):")
+		for _, source := range cfg.Sources {
+			print.Info(os.Stdout, "#  ", source)
+		}
+	}
+	print.Info(os.Stdout, "")
+	return cfg.Write(os.Stdout)
+}
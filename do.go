@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sst/opencode-sdk-go"
+	"github.com/sst/opencode-sdk-go/option"
+	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/print"
+	"github.com/thomasgormley/chisel/internal/runner"
+)
+
+// runDo implements "chisel do", which runs an instruction given on the command
+// line against a function as if it were written there as an @ai comment.
+func runDo(ctx context.Context, args []string) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var verb string
+	flags, err := parseFlags("chisel do", `[flags] <file.go:Func|file.go:line> "instruction"`, args, func(fs *flag.FlagSet) {
+		fs.StringVar(&verb, "verb", "", "directive verb, such as test, doc or review; empty for an edit")
+	})
+	if err != nil || flags.flagSet.NArg() < 2 {
+		flags.flagSet.Usage()
+		return err
+	}
+
+	file, target, err := parseTarget(flags.flagSet.Arg(0))
+	if err != nil {
+		return err
+	}
+	if flags.config.Ignored(file) {
+		return fmt.Errorf("%s is ignored by configuration", file)
+	}
+	instruction := strings.Join(flags.flagSet.Args()[1:], " ")
+
+	code, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	d, err := directive.NewParser().Synthesize(code, target, directive.Verb(verb), instruction)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	client := opencode.NewClient(option.WithBaseURL(flags.BaseURL()))
+	r, err := newRunner(client, flags)
+	if err != nil {
+		return err
+	}
+
+	print.Info(flags.out, "Running", fmt.Sprintf("%q", instruction), "on", d.Function, fmt.Sprintf("(%s:%d-%d)", file, d.StartLine, d.EndLine))
+	results, err := runSession(ctx, client, r, flags, file, []directive.AIDirective{d}, nil, nil)
+	runner.EmitSummary(flags.emitter, results)
+	if err != nil {
+		return err
+	}
+	if runner.Failed(results) > 0 {
+		return fmt.Errorf("directive failed")
+	}
+	return nil
+}
+
+// parseTarget splits "file.go:Func" or "file.go:42" into the file and the
+// function it names.
+func parseTarget(arg string) (string, directive.Target, error) {
+	file, name, ok := cutLast(arg, ":")
+	if !ok || file == "" || name == "" {
+		return "", directive.Target{}, fmt.Errorf("target %q must be file:function or file:line", arg)
+	}
+	if line, err := strconv.ParseUint(name, 10, 0); err == nil {
+		if line == 0 {
+			return "", directive.Target{}, fmt.Errorf("target %q: lines start at 1", arg)
+		}
+		return file, directive.Target{Line: uint(line)}, nil
+	}
+	return file, directive.Target{Function: name}, nil
+}
+
+// cutLast is strings.Cut around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
@@ -2,14 +2,22 @@ module github.com/thomasgormley/chisel
 
 go 1.25.1
 
-require github.com/tree-sitter/tree-sitter-go v0.25.0
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/sst/opencode-sdk-go v0.19.2
+	github.com/tree-sitter/go-tree-sitter v0.25.0
+	github.com/tree-sitter/tree-sitter-go v0.25.0
+	golang.org/x/mod v0.31.0
+	golang.org/x/tools v0.40.0
+)
 
 require (
 	github.com/mattn/go-pointer v0.0.1 // indirect
-	github.com/sst/opencode-sdk-go v0.19.2 // indirect
 	github.com/tidwall/gjson v1.14.4 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/tidwall/sjson v1.2.5 // indirect
-	github.com/tree-sitter/go-tree-sitter v0.25.0 // indirect
+	golang.org/x/sync v0.19.0 // indirect
+	golang.org/x/sys v0.39.0 // indirect
 )
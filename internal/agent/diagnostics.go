@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sst/opencode-sdk-go"
+)
+
+// Severity is an LSP diagnostic severity.
+type Severity int
+
+// LSP diagnostic severities.
+const (
+	SeverityError   Severity = 1
+	SeverityWarning Severity = 2
+	SeverityInfo    Severity = 3
+	SeverityHint    Severity = 4
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	case SeverityHint:
+		return "hint"
+	}
+	return fmt.Sprintf("severity %d", int(s))
+}
+
+// Diagnostic is a problem a language server reported in a file the agent
+// edited. Line and Column are 1-based.
+type Diagnostic struct {
+	Path     string
+	Line     int
+	Column   int
+	Severity Severity
+	Message  string
+	Source   string
+}
+
+// DiagnosticsFunc receives the current diagnostics for path each time an
+// edit to it completes. An empty slice means the file is clean.
+type DiagnosticsFunc func(path string, diagnostics []Diagnostic)
+
+// WithDiagnostics passes the diagnostics reported after each edit to fn.
+func WithDiagnostics(fn DiagnosticsFunc) HandlerOption {
+	return func(h *Handler) {
+		h.diagnostics = fn
+	}
+}
+
+// toolDiagnostics returns the diagnostics a completed edit or write tool call
+// reported, by file. The server's diagnostics event only names the file;
+// the messages arrive in the tool's metadata, which covers every file the
+// language server knows about, so only the edited file is kept when the
+// tool names one.
+func toolDiagnostics(part opencode.Part) map[string][]Diagnostic {
+	state, ok := part.State.(opencode.ToolPartState)
+	if !ok || state.Status != opencode.ToolPartStateStatusCompleted {
+		return nil
+	}
+	metadata, _ := state.Metadata.(map[string]any)
+	byPath, ok := metadata["diagnostics"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	input, _ := state.Input.(map[string]any)
+	edited, _ := input["filePath"].(string)
+
+	out := map[string][]Diagnostic{}
+	for path, list := range byPath {
+		if edited != "" && path != edited {
+			continue
+		}
+		items, _ := list.([]any)
+		diagnostics := []Diagnostic{}
+		for _, item := range items {
+			if d, ok := parseDiagnostic(path, item); ok {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+		sort.SliceStable(diagnostics, func(i, j int) bool {
+			if diagnostics[i].Line != diagnostics[j].Line {
+				return diagnostics[i].Line < diagnostics[j].Line
+			}
+			return diagnostics[i].Column < diagnostics[j].Column
+		})
+		out[path] = diagnostics
+	}
+	if edited != "" {
+		if _, ok := out[edited]; !ok {
+			out[edited] = []Diagnostic{}
+		}
+	}
+	return out
+}
+
+// parseDiagnostic decodes an LSP diagnostic, whose positions are 0-based.
+func parseDiagnostic(path string, v any) (Diagnostic, bool) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return Diagnostic{}, false
+	}
+	d := Diagnostic{Path: path, Severity: SeverityError}
+	d.Message, _ = m["message"].(string)
+	d.Source, _ = m["source"].(string)
+	if s, ok := m["severity"].(float64); ok {
+		d.Severity = Severity(s)
+	}
+	rng, _ := m["range"].(map[string]any)
+	start, ok := rng["start"].(map[string]any)
+	if !ok || d.Message == "" {
+		return Diagnostic{}, false
+	}
+	line, _ := start["line"].(float64)
+	character, _ := start["character"].(float64)
+	d.Line, d.Column = int(line)+1, int(character)+1
+	return d, true
+}
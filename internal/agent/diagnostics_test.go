@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sst/opencode-sdk-go"
+)
+
+const editCompleted = `{"type":"message.part.updated","properties":{"part":{
+	"id":"prt_1","messageID":"msg_1","sessionID":"ses_1","type":"tool","callID":"call_1","tool":"edit",
+	"state":{"status":"completed","title":"main.go","output":"","time":{"start":1,"end":2},
+		"input":{"filePath":"/repo/main.go"},
+		"metadata":{"diagnostics":{
+			"/repo/main.go":[
+				{"range":{"start":{"line":9,"character":1},"end":{"line":9,"character":5}},"severity":2,"message":"unused result"},
+				{"range":{"start":{"line":3,"character":4},"end":{"line":3,"character":8}},"severity":1,"message":"undefined: x","source":"compiler"},
+				{"range":{"start":{"line":5,"character":0},"end":{"line":5,"character":1}},"severity":4,"message":"could be simpler"}
+			],
+			"/repo/other.go":[
+				{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":1}},"severity":1,"message":"elsewhere"}
+			]
+		}}
+	}
+}}}`
+
+func TestReportDiagnostics(t *testing.T) {
+	var event opencode.EventListResponse
+	if err := event.UnmarshalJSON([]byte(editCompleted)); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	var got []Diagnostic
+	calls := 0
+	h := NewHandler(&out, WithDiagnostics(func(path string, diagnostics []Diagnostic) {
+		calls++
+		got = diagnostics
+	}))
+	h.Handle(context.Background(), event)
+	h.Handle(context.Background(), event)
+
+	want := []Diagnostic{
+		{Path: "/repo/main.go", Line: 4, Column: 5, Severity: SeverityError, Message: "undefined: x", Source: "compiler"},
+		{Path: "/repo/main.go", Line: 6, Column: 1, Severity: SeverityHint, Message: "could be simpler"},
+		{Path: "/repo/main.go", Line: 10, Column: 2, Severity: SeverityWarning, Message: "unused result"},
+	}
+	if calls != 1 {
+		t.Errorf("expected diagnostics to be passed on once, got %d calls", calls)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("\n  expected: %+v\n  got:      %+v", want, got)
+	}
+
+	printed := out.String()
+	for _, line := range []string{"2 problem(s) in /repo/main.go", "4:5: error: undefined: x", "10:2: warning: unused result"} {
+		if !strings.Contains(printed, line) {
+			t.Errorf("expected output to contain %q, got %q", line, printed)
+		}
+	}
+	if strings.Contains(printed, "could be simpler") || strings.Contains(printed, "elsewhere") {
+		t.Errorf("expected hints and other files to be left out, got %q", printed)
+	}
+}
@@ -2,13 +2,15 @@ package agent
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 
 	"github.com/sst/opencode-sdk-go"
+	"github.com/thomasgormley/chisel/internal/output"
 	"github.com/thomasgormley/chisel/internal/print"
 )
 
@@ -36,17 +38,44 @@ func permissionDialog(title, message string) DialogResponse {
 	return DialogResponse{Button: "Reject", Success: true}
 }
 
-func ListenForEvents(ctx context.Context, client *opencode.Client, sessionID string) error {
+// ListenOption is a functional option for configuring ListenForEvents.
+type ListenOption func(*listenConfig)
+
+type listenConfig struct {
+	recorder *Recorder
+	handler  *Handler
+}
+
+// WithRecorder writes every event received to rec before it is handled.
+func WithRecorder(rec *Recorder) ListenOption {
+	return func(c *listenConfig) {
+		c.recorder = rec
+	}
+}
+
+// WithHandler handles events with h instead of the default Handler, which
+// writes to stdout and answers permissions with a dialog.
+func WithHandler(h *Handler) ListenOption {
+	return func(c *listenConfig) {
+		c.handler = h
+	}
+}
+
+// ListenForEvents streams events for sessionID from the server and renders
+// them until ctx is cancelled or the stream ends.
+func ListenForEvents(ctx context.Context, client *opencode.Client, sessionID string, opts ...ListenOption) error {
+	var cfg listenConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	stream := client.Event.ListStreaming(ctx, opencode.EventListParams{})
 	defer stream.Close()
 
-	var (
-		prevToolHandled     string
-		totalTokenInput     float64
-		totalTokenOutput    float64
-		totalTokenReasoning float64
-		totalCost           float64
-	)
+	handler := cfg.handler
+	if handler == nil {
+		handler = NewHandler(os.Stdout, WithPermissionResponder(DialogResponder(client)))
+	}
 	for {
 		select {
 		case <-ctx.Done():
@@ -57,127 +86,296 @@ func ListenForEvents(ctx context.Context, client *opencode.Client, sessionID str
 			}
 
 			event := stream.Current()
-
-			switch event.Type {
-			case opencode.EventListResponseTypePermissionUpdated:
-				evt := event.AsUnion().(opencode.EventListResponseEventPermissionUpdated)
-				dialogResult := permissionDialog("Chisel Permission", "Agent is requesting permission to perform an action.")
-
-				response := opencode.SessionPermissionRespondParamsResponseReject
-				if dialogResult.Success {
-					switch dialogResult.Button {
-					case "Always":
-						response = opencode.SessionPermissionRespondParamsResponseAlways
-					case "Allow Once":
-						response = opencode.SessionPermissionRespondParamsResponseOnce
-					}
+			if !sessionIDMatches(event, sessionID) {
+				continue
+			}
+			if cfg.recorder != nil {
+				if err := cfg.recorder.Record(event); err != nil {
+					print.Warningf(handler.out, print.Wrap("Failed to record event: %s"), err)
 				}
+			}
+			handler.Handle(ctx, event)
+		}
+	}
+}
 
-				client.Session.Permissions.Respond(ctx, evt.Properties.SessionID, evt.Properties.ID, opencode.SessionPermissionRespondParams{
-					Response: opencode.F(response),
-				})
+// PermissionResponder answers a permission request raised by the agent and
+// returns the response it gave.
+type PermissionResponder func(ctx context.Context, permission opencode.Permission) opencode.SessionPermissionRespondParamsResponse
 
-			case opencode.EventListResponseTypeMessagePartUpdated:
-				evt := event.AsUnion().(opencode.EventListResponseEventMessagePartUpdated)
-				part := evt.Properties.Part
+// HandlerOption is a functional option for configuring a Handler.
+type HandlerOption func(*Handler)
 
-				switch part.Type {
-				case opencode.PartTypeReasoning, opencode.PartTypeText:
-					if evt.Properties.Delta != "" {
-						print.Infof(os.Stdout, "%s", evt.Properties.Delta)
-					}
+// WithPermissionResponder sets how permission requests are answered. Without
+// one, requests are only reported.
+func WithPermissionResponder(respond PermissionResponder) HandlerOption {
+	return func(h *Handler) {
+		h.respond = respond
+	}
+}
 
-				case opencode.PartTypeTool:
-					handleToolPart(part, prevToolHandled)
+// WithEmitter reports each handled event to e as well as rendering it.
+func WithEmitter(e *output.Emitter) HandlerOption {
+	return func(h *Handler) {
+		h.emit = e
+	}
+}
 
-				case opencode.PartTypeStepStart:
-					handleStepStartPart(part)
+// Handler renders server events and keeps running totals for the session.
+type Handler struct {
+	out         io.Writer
+	respond     PermissionResponder
+	emit        *output.Emitter
+	diagnostics DiagnosticsFunc
+
+	// toolStatus remembers the last status emitted per tool call so repeated
+	// part updates are reported once.
+	toolStatus map[string]string
+	// diagnosed remembers the tool calls whose diagnostics were reported.
+	diagnosed map[string]bool
+
+	prevToolHandled     string
+	totalTokenInput     float64
+	totalTokenOutput    float64
+	totalTokenReasoning float64
+	totalCost           float64
+}
 
-				case opencode.PartTypeStepFinish:
-					prevToolHandled = "" // reset the tool on step finish
-					handleStepFinishPart(part, &totalTokenInput, &totalTokenOutput, &totalTokenReasoning, &totalCost)
+// NewHandler creates a Handler that writes to out.
+func NewHandler(out io.Writer, opts ...HandlerOption) *Handler {
+	h := &Handler{out: out, toolStatus: map[string]string{}, diagnosed: map[string]bool{}}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
 
-				case opencode.PartTypeAgent:
-					handleAgentPart(part)
+// Handle renders a single event.
+func (h *Handler) Handle(ctx context.Context, event opencode.EventListResponse) {
+	w := h.out
 
-				case opencode.PartTypeRetry:
-					handleRetryPart(part)
+	switch event.Type {
+	case opencode.EventListResponseTypePermissionUpdated:
+		evt := event.AsUnion().(opencode.EventListResponseEventPermissionUpdated)
+		permission := output.Permission{
+			ID:    evt.Properties.ID,
+			Type:  evt.Properties.Type,
+			Title: evt.Properties.Title,
+		}
+		h.emit.Emit(output.TypePermissionRequested, permission)
+		if h.respond == nil {
+			print.Warningf(w, print.Wrap("🔐 Permission requested: %s"), evt.Properties.Title)
+			return
+		}
+		permission.Response = string(h.respond(ctx, evt.Properties))
+		h.emit.Emit(output.TypePermissionReplied, permission)
 
-				case opencode.PartTypeFile:
-					handleFilePart(part)
-				}
+	case opencode.EventListResponseTypeMessagePartUpdated:
+		evt := event.AsUnion().(opencode.EventListResponseEventMessagePartUpdated)
+		part := evt.Properties.Part
+
+		switch part.Type {
+		case opencode.PartTypeReasoning, opencode.PartTypeText:
+			if evt.Properties.Delta != "" {
+				print.Infof(w, "%s", evt.Properties.Delta)
+				h.emit.Emit(output.TypeTextDelta, output.TextDelta{
+					Kind:  string(part.Type),
+					Part:  part.ID,
+					Delta: evt.Properties.Delta,
+				})
+			}
 
-				if part.URL != "" {
-					print.Infof(os.Stdout, print.Wrap("🌐 Fetching: %s"), part.URL)
-				}
+		case opencode.PartTypeTool:
+			handleToolPart(w, part, h.prevToolHandled)
+			h.emitTool(part)
+			h.reportDiagnostics(part)
 
-			case opencode.EventListResponseTypeFileEdited:
-				evt := event.AsUnion().(opencode.EventListResponseEventFileEdited)
-				print.Successf(os.Stdout, print.Wrap("💾 Edited: %s"), evt.Properties.File)
+		case opencode.PartTypeStepStart:
+			handleStepStartPart(w, part)
 
-			case opencode.EventListResponseTypeSessionError:
-				evt := event.AsUnion().(opencode.EventListResponseEventSessionError)
-				print.Errorf(os.Stdout, print.Wrap("❌ Session error: %s"), evt.Properties.Error.Name)
+		case opencode.PartTypeStepFinish:
+			h.prevToolHandled = "" // reset the tool on step finish
+			handleStepFinishPart(w, part, &h.totalTokenInput, &h.totalTokenOutput, &h.totalTokenReasoning, &h.totalCost)
 
-			case opencode.EventListResponseTypeLspClientDiagnostics:
-				evt := event.AsUnion().(opencode.EventListResponseEventLspClientDiagnostics)
+		case opencode.PartTypeAgent:
+			handleAgentPart(w, part)
 
-				print.Warningf(os.Stdout, print.Wrap("🚨 LSP Diagnostic at %s (Server: %s)"), evt.Properties.Path, evt.Properties.ServerID)
+		case opencode.PartTypeRetry:
+			handleRetryPart(w, part)
 
-			case opencode.EventListResponseTypeSessionIdle:
-				print.Success(os.Stdout, print.WrapTop("🏁 Done."))
-				if totalTokenInput > 0 {
-					print.Infof(os.Stdout, print.WrapBottom("  Input: %.0f tokens"), totalTokenInput)
-				}
-				if totalTokenOutput > 0 {
-					print.Infof(os.Stdout, print.WrapBottom("  Output: %.0f tokens"), totalTokenOutput)
-				}
-				if totalTokenReasoning > 0 {
-					print.Infof(os.Stdout, print.WrapBottom("  Reasoning: %.0f tokens"), totalTokenReasoning)
-				}
-				if totalCost > 0 {
-					print.Infof(os.Stdout, print.WrapBottom("  Cost: $%.4f"), totalCost)
-				}
-			}
+		case opencode.PartTypeFile:
+			handleFilePart(w, part)
+		}
+
+		if part.URL != "" {
+			print.Infof(w, print.Wrap("🌐 Fetching: %s"), part.URL)
+		}
+
+	case opencode.EventListResponseTypeFileEdited:
+		evt := event.AsUnion().(opencode.EventListResponseEventFileEdited)
+		print.Successf(w, print.Wrap("💾 Edited: %s"), evt.Properties.File)
+		h.emit.Emit(output.TypeFileEdited, output.FileEdited{File: evt.Properties.File})
+
+	case opencode.EventListResponseTypeSessionError:
+		evt := event.AsUnion().(opencode.EventListResponseEventSessionError)
+		print.Errorf(w, print.Wrap("❌ Session error: %s"), evt.Properties.Error.Name)
+		h.emit.Emit(output.TypeSessionError, output.SessionError{Name: string(evt.Properties.Error.Name)})
+
+	case opencode.EventListResponseTypeLspClientDiagnostics:
+		// The event only names the file; its diagnostics are reported with
+		// the edit that caused them.
+		evt := event.AsUnion().(opencode.EventListResponseEventLspClientDiagnostics)
+		h.emit.Emit(output.TypeDiagnostic, output.Diagnostic{Path: evt.Properties.Path, Server: evt.Properties.ServerID})
+
+	case opencode.EventListResponseTypeSessionIdle:
+		print.Success(w, print.WrapTop("🏁 Done."))
+		if h.totalTokenInput > 0 {
+			print.Infof(w, print.WrapBottom("  Input: %.0f tokens"), h.totalTokenInput)
+		}
+		if h.totalTokenOutput > 0 {
+			print.Infof(w, print.WrapBottom("  Output: %.0f tokens"), h.totalTokenOutput)
+		}
+		if h.totalTokenReasoning > 0 {
+			print.Infof(w, print.WrapBottom("  Reasoning: %.0f tokens"), h.totalTokenReasoning)
+		}
+		if h.totalCost > 0 {
+			print.Infof(w, print.WrapBottom("  Cost: $%.4f"), h.totalCost)
 		}
+		h.emit.Emit(output.TypeSessionIdle, output.SessionIdle{
+			InputTokens:     h.totalTokenInput,
+			OutputTokens:    h.totalTokenOutput,
+			ReasoningTokens: h.totalTokenReasoning,
+			Cost:            h.totalCost,
+		})
 	}
 }
 
-func shouldListen(e opencode.EventListResponse, sID string) bool {
-	var properties struct {
-		SessionID string `json:"sessionID"`
+// emitTool reports a tool call each time its status changes.
+func (h *Handler) emitTool(part opencode.Part) {
+	if h.emit == nil || part.Tool == "" {
+		return
 	}
-	if raw := e.JSON.Properties.Raw(); raw != "" {
-		_ = json.Unmarshal([]byte(raw), &properties)
+	state, _ := part.State.(opencode.ToolPartState)
+	if h.toolStatus[part.CallID] == string(state.Status) {
+		return
 	}
+	h.toolStatus[part.CallID] = string(state.Status)
+	h.emit.Emit(output.TypeTool, output.Tool{
+		CallID: part.CallID,
+		Tool:   part.Tool,
+		Title:  state.Title,
+		Status: string(state.Status),
+	})
+}
 
-	if properties.SessionID == "" {
-		return true
+// reportDiagnostics prints the errors and warnings a completed edit left in
+// its file, once per tool call, and passes every diagnostic on.
+func (h *Handler) reportDiagnostics(part opencode.Part) {
+	if h.diagnosed[part.CallID] {
+		return
 	}
+	byPath := toolDiagnostics(part)
+	if byPath == nil {
+		return
+	}
+	h.diagnosed[part.CallID] = true
 
-	return properties.SessionID == sID
+	paths := make([]string, 0, len(byPath))
+	for path := range byPath {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		diagnostics := byPath[path]
+		var shown []Diagnostic
+		for _, d := range diagnostics {
+			if d.Severity <= SeverityWarning {
+				shown = append(shown, d)
+			}
+		}
+		if len(shown) > 0 {
+			print.Warningf(h.out, print.WrapTop("🚨 LSP reported %d problem(s) in %s"), len(shown), path)
+			for _, d := range shown {
+				print.Infof(h.out, "  %d:%d: %s: %s\n", d.Line, d.Column, d.Severity, d.Message)
+			}
+		}
+		for _, d := range diagnostics {
+			h.emit.Emit(output.TypeDiagnostic, output.Diagnostic{
+				Path:     d.Path,
+				Line:     d.Line,
+				Column:   d.Column,
+				Severity: d.Severity.String(),
+				Message:  d.Message,
+			})
+		}
+		if h.diagnostics != nil {
+			h.diagnostics(path, diagnostics)
+		}
+	}
+}
+
+// DialogResponder answers permission requests with a native dialog.
+func DialogResponder(client *opencode.Client) PermissionResponder {
+	return func(ctx context.Context, permission opencode.Permission) opencode.SessionPermissionRespondParamsResponse {
+		dialogResult := permissionDialog("Chisel Permission", "Agent is requesting permission to perform an action.")
+
+		response := opencode.SessionPermissionRespondParamsResponseReject
+		if dialogResult.Success {
+			switch dialogResult.Button {
+			case "Always":
+				response = opencode.SessionPermissionRespondParamsResponseAlways
+			case "Allow Once":
+				response = opencode.SessionPermissionRespondParamsResponseOnce
+			}
+		}
+
+		client.Session.Permissions.Respond(ctx, permission.SessionID, permission.ID, opencode.SessionPermissionRespondParams{
+			Response: opencode.F(response),
+		})
+		return response
+	}
+}
+
+// PolicyResponder answers permission requests with the response decide gives
+// for their type, deferring to fallback when decide returns an empty response.
+func PolicyResponder(client *opencode.Client, decide func(typ string) opencode.SessionPermissionRespondParamsResponse, fallback PermissionResponder) PermissionResponder {
+	return func(ctx context.Context, permission opencode.Permission) opencode.SessionPermissionRespondParamsResponse {
+		response := decide(permission.Type)
+		if response == "" {
+			return fallback(ctx, permission)
+		}
+		client.Session.Permissions.Respond(ctx, permission.SessionID, permission.ID, opencode.SessionPermissionRespondParams{
+			Response: opencode.F(response),
+		})
+		return response
+	}
 }
 
+// sessionIDMatches reports whether event belongs to sessionID. Events that
+// carry no session, such as file edits and diagnostics, always match.
 func sessionIDMatches(event opencode.EventListResponse, sessionID string) bool {
+	var id string
 	switch event.Type {
 	case opencode.EventListResponseTypeMessageUpdated:
+		evt := event.AsUnion().(opencode.EventListResponseEventMessageUpdated)
+		id = evt.Properties.Info.SessionID
+	case opencode.EventListResponseTypeMessagePartUpdated:
 		evt := event.AsUnion().(opencode.EventListResponseEventMessagePartUpdated)
-		return evt.Properties.Part.SessionID == sessionID
+		id = evt.Properties.Part.SessionID
 	case opencode.EventListResponseTypePermissionUpdated:
 		evt := event.AsUnion().(opencode.EventListResponseEventPermissionUpdated)
-		return evt.Properties.SessionID == sessionID
+		id = evt.Properties.SessionID
 	case opencode.EventListResponseTypeSessionError:
 		evt := event.AsUnion().(opencode.EventListResponseEventSessionError)
-		return evt.Properties.SessionID == sessionID
+		id = evt.Properties.SessionID
 	case opencode.EventListResponseTypeSessionIdle:
 		evt := event.AsUnion().(opencode.EventListResponseEventSessionIdle)
-		return evt.Properties.SessionID == sessionID
-	default:
-		return true
+		id = evt.Properties.SessionID
 	}
+	return id == "" || id == sessionID
 }
 
-func handleToolPart(part opencode.Part, prevHandledTool string) {
+func handleToolPart(w io.Writer, part opencode.Part, prevHandledTool string) {
 	if part.Tool != "" && part.Tool == prevHandledTool {
 		return
 	}
@@ -185,26 +383,27 @@ func handleToolPart(part opencode.Part, prevHandledTool string) {
 
 	if part.Tool != "" {
 		if ok && state.Title != "" {
-			print.Notef(os.Stdout, print.Wrap("🔨 Tool: %s (%s)"), part.Tool, state.Title)
+			print.Notef(w, print.Wrap("🔨 Tool: %s (%s)"), part.Tool, state.Title)
 		}
 		if ok && (state.Status == "completed" || state.Status == "error") {
-			print.Infof(os.Stdout, "\n")
+			print.Infof(w, "\n")
 		}
 	}
 }
 
-func handleStepStartPart(_ opencode.Part) {
-	print.Notef(os.Stdout, print.Wrap("⚡ Step started"))
+func handleStepStartPart(w io.Writer, _ opencode.Part) {
+	print.Notef(w, "%s", print.Wrap("⚡ Step started"))
 }
 
 func handleStepFinishPart(
+	w io.Writer,
 	part opencode.Part,
 	totalTokenInput,
 	totalTokenOutput,
 	totalTokenReasoning,
 	totalCost *float64,
 ) {
-	print.Successf(os.Stdout, print.Wrap("✅ Step completed"))
+	print.Successf(w, "%s", print.Wrap("✅ Step completed"))
 	*totalCost += part.Cost
 	if part.Tokens != nil {
 		if tokens, ok := part.Tokens.(opencode.StepFinishPartTokens); ok {
@@ -215,33 +414,33 @@ func handleStepFinishPart(
 	}
 }
 
-func handleAgentPart(part opencode.Part) {
-	print.Notef(os.Stdout, print.Wrap("🤖 Agent: %s"), part.Name)
+func handleAgentPart(w io.Writer, part opencode.Part) {
+	print.Notef(w, print.Wrap("🤖 Agent: %s"), part.Name)
 	if part.Source != nil {
 		if source, ok := part.Source.(opencode.AgentPartSource); ok {
-			print.Infof(os.Stdout, "  Source: %s (chars %d-%d)\n", source.Value, source.Start, source.End)
+			print.Infof(w, "  Source: %s (chars %d-%d)\n", source.Value, source.Start, source.End)
 		}
 	}
 }
 
-func handleRetryPart(part opencode.Part) {
-	print.Warningf(os.Stdout, print.Wrap("🔄 Retry attempt %.0f"), part.Attempt)
+func handleRetryPart(w io.Writer, part opencode.Part) {
+	print.Warningf(w, print.Wrap("🔄 Retry attempt %.0f"), part.Attempt)
 	if part.Error != nil {
 		if err, ok := part.Error.(opencode.PartRetryPartError); ok {
-			print.Infof(os.Stdout, "  Error: %s", err.Name)
-			print.Infof(os.Stdout, " - %s", err.Data.Message)
-			print.Infof(os.Stdout, "\n")
+			print.Infof(w, "  Error: %s", err.Name)
+			print.Infof(w, " - %s", err.Data.Message)
+			print.Infof(w, "\n")
 		}
 	}
 }
 
-func handleFilePart(part opencode.Part) {
+func handleFilePart(w io.Writer, part opencode.Part) {
 	if part.Filename != "" {
-		print.Notef(os.Stdout, print.Wrap("📄 File: %s"), part.Filename)
+		print.Notef(w, print.Wrap("📄 File: %s"), part.Filename)
 	} else if part.URL != "" {
-		print.Notef(os.Stdout, print.Wrap("📄 Downloading: %s"), part.URL)
+		print.Notef(w, print.Wrap("📄 Downloading: %s"), part.URL)
 	}
 	if part.Mime != "" {
-		print.Infof(os.Stdout, "  Type: %s\n", part.Mime)
+		print.Infof(w, "  Type: %s\n", part.Mime)
 	}
 }
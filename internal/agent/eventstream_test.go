@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/sst/opencode-sdk-go"
+)
+
+func TestSessionIDMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected bool
+	}{
+		{
+			name:     "part from the session",
+			raw:      `{"type":"message.part.updated","properties":{"part":{"id":"prt_1","messageID":"msg_1","sessionID":"ses_1","type":"text","text":"hi"}}}`,
+			expected: true,
+		},
+		{
+			name:     "part from another session",
+			raw:      `{"type":"message.part.updated","properties":{"part":{"id":"prt_1","messageID":"msg_1","sessionID":"ses_2","type":"text","text":"hi"}}}`,
+			expected: false,
+		},
+		{
+			name:     "message from another session",
+			raw:      `{"type":"message.updated","properties":{"info":{"id":"msg_1","role":"user","sessionID":"ses_2","time":{"created":1}}}}`,
+			expected: false,
+		},
+		{
+			name:     "idle from another session",
+			raw:      `{"type":"session.idle","properties":{"sessionID":"ses_2"}}`,
+			expected: false,
+		},
+		{
+			name:     "event without a session",
+			raw:      `{"type":"file.edited","properties":{"file":"main.go"}}`,
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var event opencode.EventListResponse
+			if err := event.UnmarshalJSON([]byte(tt.raw)); err != nil {
+				t.Fatal(err)
+			}
+			if got := sessionIDMatches(event, "ses_1"); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
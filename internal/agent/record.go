@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sst/opencode-sdk-go"
+)
+
+// RecordedEvent is a single line of a recording: the raw event as received
+// from the server and the time it arrived.
+type RecordedEvent struct {
+	Time  time.Time       `json:"time"`
+	Event json.RawMessage `json:"event"`
+}
+
+// Recorder writes events as JSON lines so they can be replayed later.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+// NewRecorder creates a Recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// Record appends event to the recording.
+func (r *Recorder) Record(event opencode.EventListResponse) error {
+	raw := event.JSON.RawJSON()
+	if raw == "" {
+		return fmt.Errorf("event %s has no raw JSON", event.Type)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(RecordedEvent{Time: r.now(), Event: json.RawMessage(raw)})
+}
+
+// ReadRecording parses a recording written by a Recorder.
+func ReadRecording(r io.Reader) ([]RecordedEvent, error) {
+	var events []RecordedEvent
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec RecordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Replay feeds recorded events through handler. Gaps between events are
+// reproduced divided by speed; a speed of zero or less replays without delay.
+func Replay(ctx context.Context, events []RecordedEvent, handler *Handler, speed float64) error {
+	for i, rec := range events {
+		if i > 0 && speed > 0 {
+			gap := time.Duration(float64(rec.Time.Sub(events[i-1].Time)) / speed)
+			if gap > 0 {
+				timer := time.NewTimer(gap)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+
+		var event opencode.EventListResponse
+		if err := event.UnmarshalJSON(rec.Event); err != nil {
+			return fmt.Errorf("event %d: %w", i+1, err)
+		}
+		handler.Handle(ctx, event)
+	}
+	return nil
+}
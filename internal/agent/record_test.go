@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sst/opencode-sdk-go"
+	"github.com/thomasgormley/chisel/internal/output"
+)
+
+func TestReplaySession(t *testing.T) {
+	f, err := os.Open("testdata/session.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	events, err := ReadRecording(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 7 {
+		t.Fatalf("expected 7 events, got %d", len(events))
+	}
+
+	var buf bytes.Buffer
+	if err := Replay(context.Background(), events, NewHandler(&buf), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "\x1b[36m\n⚡ Step started\n\x1b[0m" +
+		"Adding a check." +
+		"\x1b[36m\n🔨 Tool: edit (main.go)\n\x1b[0m\n" +
+		"\x1b[32m\n💾 Edited: main.go\n\x1b[0m" +
+		"\x1b[32m\n✅ Step completed\n\x1b[0m" +
+		"\x1b[32m\n🏁 Done.\x1b[0m\n" +
+		"  Input: 1200 tokens\n" +
+		"  Output: 80 tokens\n" +
+		"  Cost: $0.0125\n"
+
+	if got := buf.String(); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestRecorderRoundTrip(t *testing.T) {
+	var event opencode.EventListResponse
+	raw := `{"type":"file.edited","properties":{"file":"main.go"}}`
+	if err := event.UnmarshalJSON([]byte(raw)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	rec.now = func() time.Time { return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC) }
+	if err := rec.Record(event); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `{"time":"2025-01-01T00:00:00Z","event":` + raw + "}\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+
+	events, err := ReadRecording(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 1 || string(events[0].Event) != raw {
+		t.Errorf("unexpected round trip: %+v", events)
+	}
+}
+
+func TestReplayHonoursCancellation(t *testing.T) {
+	events := []RecordedEvent{
+		{Time: time.Unix(0, 0), Event: []byte(`{"type":"file.edited","properties":{"file":"a.go"}}`)},
+		{Time: time.Unix(60, 0), Event: []byte(`{"type":"file.edited","properties":{"file":"b.go"}}`)},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var buf bytes.Buffer
+	if err := Replay(ctx, events, NewHandler(&buf), 1); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("b.go")) {
+		t.Error("expected replay to stop before the delayed event")
+	}
+}
+
+func TestReplayEmitsEvents(t *testing.T) {
+	f, err := os.Open("testdata/session.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	events, err := ReadRecording(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	handler := NewHandler(io.Discard, WithEmitter(output.NewEmitter(&buf)))
+	if err := Replay(context.Background(), events, handler, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var types []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var ev output.Event
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, ev.Type)
+	}
+
+	expected := []string{
+		output.TypeTextDelta,
+		output.TypeTextDelta,
+		output.TypeTool,
+		output.TypeFileEdited,
+		output.TypeSessionIdle,
+	}
+	if !slices.Equal(types, expected) {
+		t.Errorf("Expected %v, got %v", expected, types)
+	}
+}
@@ -0,0 +1,402 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/thomasgormley/chisel/internal/validate"
+)
+
+// FileName is the project configuration file, discovered by walking up from
+// the target file.
+const FileName = ".chisel.toml"
+
+// Config holds every setting that can come from a file, the environment or a
+// flag. Later layers override earlier ones key by key:
+//
+//  1. built-in defaults
+//  2. the user config, <user config dir>/chisel/config.toml
+//  3. the nearest .chisel.toml above the target file
+//  4. CHISEL_* environment variables
+//  5. command-line flags
+type Config struct {
+	Dir string `toml:"dir"`
+
+	Server   Server   `toml:"server"`
+	Model    Model    `toml:"model"`
+	Validate Validate `toml:"validate"`
+	Context  Context  `toml:"context"`
+	Prompts  Prompts  `toml:"prompts"`
+
+	// Permissions maps a permission type such as "edit" or "bash", or "*" for
+	// any other, to how it is answered: ask, allow, always or deny.
+	Permissions map[string]string `toml:"permissions"`
+
+	// Ignore lists glob patterns for files chisel never processes. Patterns
+	// match paths relative to Root, or base names.
+	Ignore []string `toml:"ignore"`
+
+	// Root is the directory of the project config, if one was found.
+	Root string `toml:"-"`
+	// Sources lists the files and environment variables that were applied.
+	Sources []string `toml:"-"`
+}
+
+type Server struct {
+	Host string `toml:"host"`
+	Port string `toml:"port"`
+}
+
+type Model struct {
+	Name     string `toml:"name"`
+	Provider string `toml:"provider"`
+}
+
+type Validate struct {
+	Checks       []string `toml:"checks"`
+	RepairRounds int      `toml:"repair_rounds"`
+	FixImports   bool     `toml:"fix_imports"`
+	Test         bool     `toml:"test"`
+	TestRelated  bool     `toml:"test_related"`
+}
+
+// Context controls the read-only context added to prompts.
+type Context struct {
+	// Budget bounds, in bytes, the same-file declarations included. Zero
+	// leaves them out.
+	Budget int `toml:"budget"`
+	// Types adds declarations from other files and packages, found by loading
+	// the package with type information. Slower, so off by default.
+	Types bool `toml:"types"`
+	// Callers bounds, in bytes, the callers and tests of the target included.
+	// Finding them scans the whole module for every directive, so zero, the
+	// default, leaves them out; "@ai(callers=true)" asks for them per
+	// directive.
+	Callers int `toml:"callers"`
+}
+
+// Prompts point at files that replace the built-in prompts. Relative paths
+// resolve against the config file that set them.
+type Prompts struct {
+	System   string `toml:"system,omitempty"`
+	ReadOnly string `toml:"read_only,omitempty"`
+	Context  string `toml:"context,omitempty"`
+	Repair   string `toml:"repair,omitempty"`
+	// Verbs maps a directive verb such as "test" to its system prompt.
+	Verbs map[string]string `toml:"verbs,omitempty"`
+}
+
+// Permission answers accepted in Permissions.
+const (
+	PermissionAsk    = "ask"
+	PermissionAllow  = "allow"
+	PermissionAlways = "always"
+	PermissionDeny   = "deny"
+)
+
+// Default returns the built-in configuration.
+func Default() Config {
+	return Config{
+		Server: Server{Host: "http://localhost", Port: "3366"},
+		Model:  Model{Name: "big-pickle", Provider: "opencode"},
+		Validate: Validate{
+			Checks:       validate.DefaultChecks,
+			RepairRounds: 2,
+			FixImports:   true,
+		},
+		Context:     Context{Budget: 4096},
+		Permissions: map[string]string{"*": PermissionAsk},
+	}
+}
+
+// Load builds the configuration for start, a file or directory, from the
+// defaults, the user config, the nearest project config and the environment.
+func Load(start string) (Config, error) {
+	user := ""
+	if dir, err := os.UserConfigDir(); err == nil {
+		user = filepath.Join(dir, "chisel", "config.toml")
+	}
+	return load(start, user, os.Environ())
+}
+
+func load(start, userFile string, environ []string) (Config, error) {
+	cfg := Default()
+
+	if userFile != "" {
+		if err := cfg.merge(userFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return cfg, err
+		}
+	}
+
+	if project, ok := find(start); ok {
+		if err := cfg.merge(project); err != nil {
+			return cfg, err
+		}
+		cfg.Root = filepath.Dir(project)
+	}
+
+	if err := cfg.applyEnv(environ); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Check()
+}
+
+// find returns the nearest FileName in start's directory or above.
+func find(start string) (string, bool) {
+	dir, err := filepath.Abs(start)
+	if err != nil {
+		return "", false
+	}
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	for {
+		path := filepath.Join(dir, FileName)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// merge decodes path over cfg. Keys absent from the file keep their values.
+func (c *Config) merge(path string) error {
+	var layer Config
+	md, err := toml.DecodeFile(path, &layer)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return fmt.Errorf("%s: unknown key %s", path, undecoded[0])
+	}
+
+	base := filepath.Dir(path)
+	rel := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(base, p)
+	}
+
+	set := func(keys ...string) bool { return md.IsDefined(keys...) }
+	if set("dir") {
+		c.Dir = rel(layer.Dir)
+	}
+	if set("server", "host") {
+		c.Server.Host = layer.Server.Host
+	}
+	if set("server", "port") {
+		c.Server.Port = layer.Server.Port
+	}
+	if set("model", "name") {
+		c.Model.Name = layer.Model.Name
+	}
+	if set("model", "provider") {
+		c.Model.Provider = layer.Model.Provider
+	}
+	if set("validate", "checks") {
+		c.Validate.Checks = layer.Validate.Checks
+	}
+	if set("validate", "repair_rounds") {
+		c.Validate.RepairRounds = layer.Validate.RepairRounds
+	}
+	if set("validate", "fix_imports") {
+		c.Validate.FixImports = layer.Validate.FixImports
+	}
+	if set("validate", "test") {
+		c.Validate.Test = layer.Validate.Test
+	}
+	if set("validate", "test_related") {
+		c.Validate.TestRelated = layer.Validate.TestRelated
+	}
+	if set("context", "budget") {
+		c.Context.Budget = layer.Context.Budget
+	}
+	if set("context", "types") {
+		c.Context.Types = layer.Context.Types
+	}
+	if set("context", "callers") {
+		c.Context.Callers = layer.Context.Callers
+	}
+	if set("prompts", "system") {
+		c.Prompts.System = rel(layer.Prompts.System)
+	}
+	if set("prompts", "read_only") {
+		c.Prompts.ReadOnly = rel(layer.Prompts.ReadOnly)
+	}
+	if set("prompts", "context") {
+		c.Prompts.Context = rel(layer.Prompts.Context)
+	}
+	if set("prompts", "repair") {
+		c.Prompts.Repair = rel(layer.Prompts.Repair)
+	}
+	for verb, path := range layer.Prompts.Verbs {
+		if c.Prompts.Verbs == nil {
+			c.Prompts.Verbs = map[string]string{}
+		}
+		c.Prompts.Verbs[verb] = rel(path)
+	}
+	for typ, answer := range layer.Permissions {
+		c.Permissions[typ] = answer
+	}
+	if set("ignore") {
+		c.Ignore = layer.Ignore
+	}
+
+	c.Sources = append(c.Sources, path)
+	return nil
+}
+
+// applyEnv overrides cfg with CHISEL_* variables from environ.
+func (c *Config) applyEnv(environ []string) error {
+	applied := len(c.Sources)
+	defer func() { slices.Sort(c.Sources[applied:]) }()
+
+	env := map[string]string{}
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(k, "CHISEL_") {
+			env[k] = v
+		}
+	}
+
+	str := map[string]*string{
+		"CHISEL_DIR":      &c.Dir,
+		"CHISEL_HOST":     &c.Server.Host,
+		"CHISEL_PORT":     &c.Server.Port,
+		"CHISEL_MODEL":    &c.Model.Name,
+		"CHISEL_PROVIDER": &c.Model.Provider,
+	}
+	for k, p := range str {
+		if v, ok := env[k]; ok {
+			*p = v
+			c.Sources = append(c.Sources, k)
+		}
+	}
+
+	lists := map[string]*[]string{
+		"CHISEL_VALIDATE": &c.Validate.Checks,
+		"CHISEL_IGNORE":   &c.Ignore,
+	}
+	for k, p := range lists {
+		if v, ok := env[k]; ok {
+			*p = splitList(v)
+			c.Sources = append(c.Sources, k)
+		}
+	}
+
+	bools := map[string]*bool{
+		"CHISEL_FIX_IMPORTS":   &c.Validate.FixImports,
+		"CHISEL_TEST":          &c.Validate.Test,
+		"CHISEL_TEST_RELATED":  &c.Validate.TestRelated,
+		"CHISEL_CONTEXT_TYPES": &c.Context.Types,
+	}
+	for k, p := range bools {
+		if v, ok := env[k]; ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			*p = b
+			c.Sources = append(c.Sources, k)
+		}
+	}
+
+	ints := map[string]*int{
+		"CHISEL_REPAIR_ROUNDS":   &c.Validate.RepairRounds,
+		"CHISEL_CONTEXT_BUDGET":  &c.Context.Budget,
+		"CHISEL_CONTEXT_CALLERS": &c.Context.Callers,
+	}
+	for k, p := range ints {
+		if v, ok := env[k]; ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			*p = n
+			c.Sources = append(c.Sources, k)
+		}
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// Check reports settings that cannot be used.
+func (c Config) Check() error {
+	for typ, answer := range c.Permissions {
+		switch answer {
+		case PermissionAsk, PermissionAllow, PermissionAlways, PermissionDeny:
+		default:
+			return fmt.Errorf("permissions.%s must be ask, allow, always or deny, got %q", typ, answer)
+		}
+	}
+	for _, pattern := range c.Ignore {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("ignore pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Permission returns how requests of type typ are answered.
+func (c Config) Permission(typ string) string {
+	if answer, ok := c.Permissions[typ]; ok {
+		return answer
+	}
+	if answer, ok := c.Permissions["*"]; ok {
+		return answer
+	}
+	return PermissionAsk
+}
+
+// Ignored reports whether path matches an ignore pattern. A pattern ending in
+// "/" or "/**" ignores everything under that directory.
+func (c Config) Ignored(path string) bool {
+	rel := path
+	if c.Root != "" {
+		if abs, err := filepath.Abs(path); err == nil {
+			if r, err := filepath.Rel(c.Root, abs); err == nil && !strings.HasPrefix(r, "..") {
+				rel = r
+			}
+		}
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, pattern := range c.Ignore {
+		if dir, ok := strings.CutSuffix(strings.TrimSuffix(pattern, "**"), "/"); ok {
+			if rel == dir || strings.HasPrefix(rel, dir+"/") {
+				return true
+			}
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Write prints cfg as TOML.
+func (c Config) Write(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(c)
+}
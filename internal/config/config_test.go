@@ -0,0 +1,149 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	root := t.TempDir()
+	user := filepath.Join(root, "user", "config.toml")
+	writeFile(t, user, `
+[server]
+host = "http://devbox"
+port = "4000"
+
+[model]
+name = "user-model"
+
+[permissions]
+bash = "deny"
+`)
+	project := filepath.Join(root, "repo", FileName)
+	writeFile(t, project, `
+dir = "."
+ignore = ["*_gen.go"]
+
+[model]
+provider = "anthropic"
+
+[context]
+budget = 2048
+
+[prompts]
+system = "prompts/system.md"
+
+[prompts.verbs]
+test = "prompts/test.md"
+
+[permissions]
+edit = "always"
+`)
+	target := filepath.Join(root, "repo", "pkg", "main.go")
+	writeFile(t, target, "package main\n")
+
+	cfg, err := load(target, user, []string{"CHISEL_PORT=5000", "CHISEL_TEST=true", "OTHER=1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	repo := filepath.Join(root, "repo")
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"host from user", cfg.Server.Host, "http://devbox"},
+		{"port from env", cfg.Server.Port, "5000"},
+		{"model from user", cfg.Model.Name, "user-model"},
+		{"provider from project", cfg.Model.Provider, "anthropic"},
+		{"dir relative to project", cfg.Dir, repo},
+		{"prompt relative to project", cfg.Prompts.System, filepath.Join(repo, "prompts", "system.md")},
+		{"verb prompt relative to project", cfg.Prompts.Verbs["test"], filepath.Join(repo, "prompts", "test.md")},
+		{"root", cfg.Root, repo},
+		{"default repair rounds", cfg.Validate.RepairRounds, 2},
+		{"test from env", cfg.Validate.Test, true},
+		{"context budget from project", cfg.Context.Budget, 2048},
+		{"bash permission", cfg.Permission("bash"), PermissionDeny},
+		{"edit permission", cfg.Permission("edit"), PermissionAlways},
+		{"fallback permission", cfg.Permission("webfetch"), PermissionAsk},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	wantSources := []string{user, project, "CHISEL_PORT", "CHISEL_TEST"}
+	if !slices.Equal(cfg.Sources, wantSources) {
+		t.Errorf("expected sources %v, got %v", wantSources, cfg.Sources)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := load(dir, filepath.Join(dir, "missing.toml"), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Model.Name != "big-pickle" || cfg.Server.Port != "3366" || len(cfg.Sources) != 0 {
+		t.Errorf("expected defaults, got %+v", cfg)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		env     []string
+	}{
+		{name: "unknown key", content: "modle = \"x\"\n"},
+		{name: "bad permission", content: "[permissions]\nbash = \"sometimes\"\n"},
+		{name: "bad env bool", env: []string{"CHISEL_TEST=maybe"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.content != "" {
+				writeFile(t, filepath.Join(dir, FileName), tt.content)
+			}
+			if _, err := load(dir, "", tt.env); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	cfg := Config{
+		Root:   "/repo",
+		Ignore: []string{"*_gen.go", "vendor/", "internal/legacy/**", "cmd/tool/main.go"},
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/repo/api/types_gen.go", true},
+		{"/repo/vendor/x/y.go", true},
+		{"/repo/internal/legacy/old.go", true},
+		{"/repo/cmd/tool/main.go", true},
+		{"/repo/cmd/other/main.go", false},
+		{"/repo/internal/service.go", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.Ignored(tt.path); got != tt.want {
+			t.Errorf("Ignored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
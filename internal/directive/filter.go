@@ -0,0 +1,92 @@
+package directive
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Filter narrows the directives of a file. Each selector is a directive ID, a
+// line number such as "120" or "file.go:120", or a glob matched against the
+// qualified or bare function name such as "(*Service).*" or "Handle*".
+type Filter struct {
+	// Only keeps directives matching any selector. Empty keeps all.
+	Only []string
+	// Skip drops directives matching any selector.
+	Skip []string
+}
+
+// Check reports selectors that are not valid globs.
+func (f Filter) Check() error {
+	for _, sel := range append(f.Only[:len(f.Only):len(f.Only)], f.Skip...) {
+		if _, err := path.Match(sel, ""); err != nil {
+			return fmt.Errorf("selector %q: %w", sel, err)
+		}
+	}
+	return nil
+}
+
+// Empty reports whether f selects every directive.
+func (f Filter) Empty() bool {
+	return len(f.Only) == 0 && len(f.Skip) == 0
+}
+
+// Apply returns the directives from file that f selects, in order.
+func (f Filter) Apply(file string, directives []AIDirective) []AIDirective {
+	var kept []AIDirective
+	for _, d := range directives {
+		if len(f.Only) > 0 && !matchesAny(f.Only, file, d) {
+			continue
+		}
+		if matchesAny(f.Skip, file, d) {
+			continue
+		}
+		kept = append(kept, d)
+	}
+	return kept
+}
+
+func matchesAny(selectors []string, file string, d AIDirective) bool {
+	for _, sel := range selectors {
+		if matches(sel, file, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether sel selects d from file.
+func matches(sel, file string, d AIDirective) bool {
+	if sel == d.ID() {
+		return true
+	}
+
+	lineText := sel
+	if f, l, ok := strings.Cut(sel, ":"); ok {
+		if !sameFile(f, file) {
+			return false
+		}
+		lineText = l
+	}
+	if line, err := strconv.ParseUint(lineText, 10, 0); err == nil {
+		first := d.StartLine
+		if d.CommentLine > 0 && d.CommentLine < first {
+			first = d.CommentLine
+		}
+		return uint(line) >= first && uint(line) <= d.EndLine
+	}
+
+	if ok, _ := path.Match(sel, d.Function); ok {
+		return true
+	}
+	ok, _ := path.Match(sel, d.Name)
+	return ok && d.Name != ""
+}
+
+// sameFile reports whether name, as typed by the user, refers to file.
+func sameFile(name, file string) bool {
+	name, file = filepath.ToSlash(filepath.Clean(name)), filepath.ToSlash(filepath.Clean(file))
+	return name == file || strings.HasSuffix(file, "/"+name) || strings.HasSuffix(name, "/"+file)
+}
@@ -0,0 +1,58 @@
+package directive
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	code := []byte(`package main
+
+// @ai validate input
+func HandleCreate() {
+}
+
+func HandleDelete() {
+	// @ai soft delete
+}
+
+func helper() {
+	// @ai inline this
+}
+`)
+	directives, err := NewParser().Parse(code)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "empty", want: []string{"HandleCreate", "HandleDelete", "helper"}},
+		{name: "glob", filter: Filter{Only: []string{"Handle*"}}, want: []string{"HandleCreate", "HandleDelete"}},
+		{name: "qualified glob", filter: Filter{Only: []string{"*.func1"}}},
+		{name: "skip glob", filter: Filter{Skip: []string{"Handle*"}}, want: []string{"helper"}},
+		{name: "doc comment line", filter: Filter{Only: []string{"3"}}, want: []string{"HandleCreate"}},
+		{name: "body line with file", filter: Filter{Only: []string{"pkg/api.go:8"}}, want: []string{"HandleDelete"}},
+		{name: "line in other file", filter: Filter{Only: []string{"other.go:8"}}},
+		{name: "id", filter: Filter{Only: []string{directives[2].ID()}}, want: []string{"helper"}},
+		{name: "only and skip", filter: Filter{Only: []string{"Handle*"}, Skip: []string{"HandleDelete"}}, want: []string{"HandleCreate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, d := range tt.filter.Apply("pkg/api.go", directives) {
+				got = append(got, d.Function)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if err := (Filter{Only: []string{"[a-"}}).Check(); err == nil {
+		t.Error("expected an error for a bad glob")
+	}
+}
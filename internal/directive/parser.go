@@ -1,16 +1,51 @@
 package directive
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"regexp"
 	"strings"
 
 	ts "github.com/tree-sitter/go-tree-sitter"
 	ts_go "github.com/tree-sitter/tree-sitter-go/bindings/go"
 )
 
-// aiCommentQuery matches comments that start with "// @ai".
-const aiCommentQuery = `((comment) @ai.comment (#match? @ai.comment "^//\\s*@ai"))`
+// aiCommentQuery matches comments that start with "// @ai", optionally with a
+// verb such as "@ai:test" and arguments such as "@ai(target=outer)". Markers
+// such as "@ai-done" are deliberately excluded so processed directives are
+// skipped.
+const aiCommentQuery = `((comment) @ai.comment (#match? @ai.comment "^//\\s*@ai(:[a-z]+)?(\\([^)]*\\))?(\\s|$)"))`
+
+// aiFailedCommentQuery additionally matches directives marked "@ai-failed(...)"
+// by an earlier run, for retrying them.
+const aiFailedCommentQuery = `((comment) @ai.comment (#match? @ai.comment "^//\\s*@ai(:[a-z]+)?(\\([^)]*\\))?(\\s|$|-failed\\()"))`
+
+// promptMarkerPattern matches the marker that opens a directive's instruction.
+var promptMarkerPattern = regexp.MustCompile(`^\s*@ai(?::[a-z]+)?(?:\([^)]*\))?(?:-failed\([^)]*\))?(\s+|$)`)
+
+// verbPattern captures the verb of a marker such as "@ai:test" on any line of
+// a comment block, since ordinary comments may come before the marker.
+var verbPattern = regexp.MustCompile(`(?m)^[ \t]*//\s*@ai:([a-z]+)`)
+
+// Verb selects what a directive asks for. The zero value is a plain edit.
+type Verb string
+
+const (
+	VerbEdit    Verb = ""
+	VerbTest    Verb = "test"
+	VerbDoc     Verb = "doc"
+	VerbExplain Verb = "explain"
+	VerbReview  Verb = "review"
+	VerbFix     Verb = "fix"
+)
+
+// TargetOuter is the value of the "target" attribute, as in
+// "@ai(target=outer)", that makes a directive inside a function literal apply
+// to the enclosing function instead of the literal.
+const TargetOuter = "outer"
 
 // functionKinds defines AST node types that represent function-like constructs.
 var functionKinds = map[string]bool{
@@ -21,8 +56,16 @@ var functionKinds = map[string]bool{
 
 // AIDirective represents an @ai comment and its enclosing function context.
 type AIDirective struct {
-	Comment      string
-	Function     string
+	Verb    Verb
+	Comment string
+	// Function is the qualified name of the target, as the Go toolchain
+	// prints it: "Parse", "(*Service).GetUser", or "Handler.func1" for the
+	// first function literal in Handler. Literal numbers shift as literals
+	// are added or removed, so ID and Locate ignore them.
+	Function string
+	// Name is the bare identifier of the target, or of the named function
+	// enclosing a literal; empty for literals outside any function.
+	Name         string
 	Source       string
 	StartLine    uint
 	EndLine      uint
@@ -30,18 +73,64 @@ type AIDirective struct {
 	EndByte      uint
 	CommentStart uint
 	CommentEnd   uint
+	// CommentLine is the 1-based line the comment block starts on.
+	CommentLine uint
+
+	// Enclosing is the outermost function around a target that is a function
+	// literal, given to the agent as read-only context. Nil otherwise.
+	Enclosing *Enclosing
+}
+
+// Enclosing describes the function a function literal is declared in.
+type Enclosing struct {
+	Function  string
+	Source    string
+	StartLine uint
+	EndLine   uint
 }
 
 // Parser extracts AI directives from Go source code using tree-sitter.
 type Parser struct {
-	language *ts.Language
+	language      *ts.Language
+	includeFailed bool
+}
+
+// ParserOption is a functional option for configuring a Parser.
+type ParserOption func(*Parser)
+
+// WithFailed makes the parser return directives marked "@ai-failed" by an
+// earlier run, which are skipped by default.
+func WithFailed(include bool) ParserOption {
+	return func(p *Parser) {
+		p.includeFailed = include
+	}
 }
 
 // NewParser creates a new Parser configured for Go source code.
-func NewParser() *Parser {
-	return &Parser{
+func NewParser(opts ...ParserOption) *Parser {
+	p := &Parser{
 		language: ts.NewLanguage(ts_go.Language()),
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// ID returns a short identifier for the directive that is stable across runs
+// as long as its function and comment text are unchanged.
+func (d *AIDirective) ID() string {
+	sum := sha256.Sum256([]byte(d.stableName() + "\x00" + d.Comment))
+	return hex.EncodeToString(sum[:4])
+}
+
+// literalSuffix matches the numbering qualifiedName gives function literals.
+var literalSuffix = regexp.MustCompile(`\.func\d+(?:\.\d+)*$`)
+
+// stableName returns Function without the numbering of a function literal,
+// leaving the function or method that declares it.
+func (d *AIDirective) stableName() string {
+	return literalSuffix.ReplaceAllString(d.Function, "")
 }
 
 func (d *AIDirective) Prompt() (string, error) {
@@ -51,7 +140,7 @@ func (d *AIDirective) Prompt() (string, error) {
 	for _, line := range lines {
 		line = strings.TrimLeft(line, " \t")
 		line = strings.TrimPrefix(line, "// ")
-		line = strings.TrimPrefix(line, "@ai ")
+		line = promptMarkerPattern.ReplaceAllString(line, "")
 		line = strings.TrimSpace(line)
 		if line != "" {
 			result = append(result, line)
@@ -61,6 +150,52 @@ func (d *AIDirective) Prompt() (string, error) {
 	return strings.Join(result, "\n"), nil
 }
 
+// attributesPattern captures the arguments written with a directive, as in
+// "@ai(target=outer)", and those of a suffix added by a run, as in
+// "@ai-failed(validation, run=1a2b3c4d)".
+var attributesPattern = regexp.MustCompile(`@ai(?::[a-z]+)?(?:\(([^)]*)\))?(?:-(\w+)\(([^)]*)\))?`)
+
+// Attributes returns the arguments carried by the directive's marker. Named
+// arguments are keyed by name. The first, unnamed argument of a suffix is
+// keyed by the suffix, so a directive marked
+// "@ai(target=outer)-failed(validation, run=1a2b3c4d)" yields target=outer,
+// failed=validation and run=1a2b3c4d. Plain "@ai" directives have no
+// attributes.
+func (d *AIDirective) Attributes() map[string]string {
+	return parseAttributes(d.Comment)
+}
+
+func parseAttributes(comment string) map[string]string {
+	attrs := map[string]string{}
+	m := attributesPattern.FindStringSubmatch(comment)
+	if m == nil {
+		return attrs
+	}
+	for _, arg := range strings.Split(m[1], ",") {
+		if key, value, ok := strings.Cut(arg, "="); ok {
+			attrs[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	for i, arg := range strings.Split(m[3], ",") {
+		arg = strings.TrimSpace(arg)
+		if key, value, ok := strings.Cut(arg, "="); ok {
+			attrs[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		} else if i == 0 && arg != "" {
+			attrs[m[2]] = arg
+		}
+	}
+	return attrs
+}
+
+// parseVerb returns the verb of the directive comment, if any.
+func parseVerb(comment string) Verb {
+	m := verbPattern.FindStringSubmatch(comment)
+	if m == nil {
+		return VerbEdit
+	}
+	return Verb(m[1])
+}
+
 // Parse extracts all AI directives from the given Go source code.
 func (p *Parser) Parse(code []byte) ([]AIDirective, error) {
 	parser := ts.NewParser()
@@ -85,7 +220,11 @@ func (p *Parser) Parse(code []byte) ([]AIDirective, error) {
 
 // extractDirectives runs the query and builds the directive list.
 func (p *Parser) extractDirectives(code []byte, root *ts.Node) ([]AIDirective, error) {
-	query, err := ts.NewQuery(p.language, aiCommentQuery)
+	source := aiCommentQuery
+	if p.includeFailed {
+		source = aiFailedCommentQuery
+	}
+	query, err := ts.NewQuery(p.language, source)
 	if err != nil {
 		return nil, fmt.Errorf("creating query: %w", err)
 	}
@@ -115,22 +254,129 @@ func (p *Parser) extractDirectives(code []byte, root *ts.Node) ([]AIDirective, e
 		}
 
 		commentText, commentStart, commentEnd := collectCommentBlock(code, &commentNode)
-		directives = append(directives, AIDirective{
-			Comment:      commentText,
-			Function:     extractFunctionName(code, funcNode),
-			Source:       extractFunctionSource(code, funcNode),
-			StartLine:    funcNode.StartPosition().Row + 1,
-			EndLine:      funcNode.EndPosition().Row + 1,
-			StartByte:    funcNode.StartByte(),
-			EndByte:      funcNode.EndByte(),
-			CommentStart: commentStart,
-			CommentEnd:   commentEnd,
-		})
+		d := newDirective(code, funcNode, commentText)
+		d.CommentStart = commentStart
+		d.CommentEnd = commentEnd
+		d.CommentLine = uint(bytes.Count(code[:commentStart], []byte("\n"))) + 1
+		directives = append(directives, d)
 	}
 
 	return directives, nil
 }
 
+// Target names the function a command-line directive applies to: by name, or
+// by a line inside it when Function is empty.
+type Target struct {
+	Function string
+	Line     uint
+}
+
+// Synthesize builds the directive that instruction would produce as an "// @ai"
+// comment in the target function, without touching code. The directive has no
+// comment in the source, so CommentStart and CommentEnd are zero.
+func (p *Parser) Synthesize(code []byte, target Target, verb Verb, instruction string) (AIDirective, error) {
+	parser := ts.NewParser()
+	defer parser.Close()
+
+	if err := parser.SetLanguage(p.language); err != nil {
+		return AIDirective{}, fmt.Errorf("setting language: %w", err)
+	}
+	tree := parser.Parse(code, nil)
+	defer tree.Close()
+
+	funcNode, err := findTarget(code, tree.RootNode(), target)
+	if err != nil {
+		return AIDirective{}, err
+	}
+
+	marker := "@ai"
+	if verb != VerbEdit {
+		marker += ":" + string(verb)
+	}
+	lines := strings.Split(strings.TrimSpace(instruction), "\n")
+	lines[0] = strings.TrimSpace(marker + " " + lines[0])
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace("// " + strings.TrimSpace(line))
+	}
+
+	return newDirective(code, funcNode, strings.Join(lines, "\n")), nil
+}
+
+// newDirective builds the directive for comment targeting funcNode. A literal
+// is retargeted to its outermost enclosing function when the comment asks for
+// "target=outer", and otherwise gets that function as context.
+func newDirective(code []byte, funcNode *ts.Node, comment string) AIDirective {
+	var outer *ts.Node
+	for p := enclosingFunction(funcNode); p != nil; p = enclosingFunction(p) {
+		outer = p
+	}
+	if outer != nil && parseAttributes(comment)["target"] == TargetOuter {
+		funcNode, outer = outer, nil
+	}
+
+	d := AIDirective{
+		Verb:      parseVerb(comment),
+		Comment:   comment,
+		Function:  qualifiedName(code, funcNode),
+		Name:      bareName(code, funcNode),
+		Source:    extractFunctionSource(code, funcNode),
+		StartLine: funcNode.StartPosition().Row + 1,
+		EndLine:   funcNode.EndPosition().Row + 1,
+		StartByte: funcNode.StartByte(),
+		EndByte:   funcNode.EndByte(),
+	}
+	if outer != nil {
+		d.Enclosing = &Enclosing{
+			Function:  qualifiedName(code, outer),
+			Source:    extractFunctionSource(code, outer),
+			StartLine: outer.StartPosition().Row + 1,
+			EndLine:   outer.EndPosition().Row + 1,
+		}
+	}
+	return d
+}
+
+// findTarget returns the function named by target, either qualified or bare,
+// or the innermost function containing its line.
+func findTarget(code []byte, root *ts.Node, target Target) (*ts.Node, error) {
+	var matches []*ts.Node
+	var walk func(n *ts.Node)
+	walk = func(n *ts.Node) {
+		if functionKinds[n.Kind()] {
+			switch {
+			case target.Function != "":
+				name := n.ChildByFieldName("name")
+				if qualifiedName(code, n) == target.Function || name != nil && nodeText(code, name) == target.Function {
+					matches = append(matches, n)
+				}
+			case n.StartPosition().Row+1 <= target.Line && target.Line <= n.EndPosition().Row+1:
+				// Children are visited later, so the innermost function ends up last.
+				matches = append(matches, n)
+			}
+		}
+		for i := range n.NamedChildCount() {
+			walk(n.NamedChild(i))
+		}
+	}
+	walk(root)
+
+	switch {
+	case target.Function == "" && len(matches) == 0:
+		return nil, fmt.Errorf("no function at line %d", target.Line)
+	case target.Function == "":
+		return matches[len(matches)-1], nil
+	case len(matches) == 0:
+		return nil, fmt.Errorf("no function named %q", target.Function)
+	case len(matches) > 1:
+		var names []string
+		for _, m := range matches {
+			names = append(names, qualifiedName(code, m))
+		}
+		return nil, fmt.Errorf("%d functions are named %q (%s); use a qualified name or a line number", len(matches), target.Function, strings.Join(names, ", "))
+	}
+	return matches[0], nil
+}
+
 // findAssociatedFunction finds the function associated with a comment node.
 // It first walks up the AST for comments inside functions, then checks
 // following siblings for doc-style comments that precede a function.
@@ -157,14 +403,117 @@ func findAssociatedFunction(n *ts.Node) *ts.Node {
 	return nil
 }
 
-// extractFunctionName returns the name of a function node, or "<anonymous>"
-// for closures/literals that have no name.
-func extractFunctionName(code []byte, funcNode *ts.Node) string {
-	nameNode := funcNode.ChildByFieldName("name")
-	if nameNode == nil {
-		return "<anonymous>"
+// qualifiedName returns the name of a function node as the Go toolchain
+// prints it. Methods carry their receiver type, "(*Service).GetUser", and
+// literals are numbered within their enclosing function, "Handler.func1",
+// with nested literals numbered again, "Handler.func1.2". Literals outside
+// any function are numbered across the package-level declarations of the
+// file, "glob..func1"; the toolchain numbers them across the package.
+func qualifiedName(code []byte, funcNode *ts.Node) string {
+	switch funcNode.Kind() {
+	case "func_literal":
+		parent := enclosingFunction(funcNode)
+		if parent == nil {
+			root := funcNode
+			for root.Parent() != nil {
+				root = root.Parent()
+			}
+			return fmt.Sprintf("glob..func%d", literalIndex(root, funcNode))
+		}
+		index := literalIndex(parent, funcNode)
+		if parent.Kind() == "func_literal" {
+			return fmt.Sprintf("%s.%d", qualifiedName(code, parent), index)
+		}
+		return fmt.Sprintf("%s.func%d", qualifiedName(code, parent), index)
+
+	case "method_declaration":
+		name := bareName(code, funcNode)
+		if recv := receiverType(code, funcNode); recv != "" {
+			return recv + "." + name
+		}
+		return name
+	}
+	return bareName(code, funcNode)
+}
+
+// bareName returns the identifier of a function node, or of the named
+// function enclosing a literal.
+func bareName(code []byte, funcNode *ts.Node) string {
+	for n := funcNode; n != nil; n = enclosingFunction(n) {
+		if nameNode := n.ChildByFieldName("name"); nameNode != nil {
+			return nodeText(code, nameNode)
+		}
+	}
+	return ""
+}
+
+// receiverType returns a method's receiver type as "(*T)" or "T", without
+// type parameters.
+func receiverType(code []byte, method *ts.Node) string {
+	params := method.ChildByFieldName("receiver")
+	if params == nil || params.NamedChildCount() == 0 {
+		return ""
+	}
+	typ := params.NamedChild(0).ChildByFieldName("type")
+	if typ == nil {
+		return ""
+	}
+	pointer := typ.Kind() == "pointer_type"
+	if pointer && typ.NamedChildCount() > 0 {
+		typ = typ.NamedChild(0)
+	}
+	if typ.Kind() == "generic_type" {
+		if base := typ.ChildByFieldName("type"); base != nil {
+			typ = base
+		}
+	}
+	if pointer {
+		return "(*" + nodeText(code, typ) + ")"
+	}
+	return nodeText(code, typ)
+}
+
+// enclosingFunction returns the nearest function node containing n.
+func enclosingFunction(n *ts.Node) *ts.Node {
+	for p := n.Parent(); p != nil; p = p.Parent() {
+		if functionKinds[p.Kind()] {
+			return p
+		}
+	}
+	return nil
+}
+
+// literalIndex returns the 1-based position of lit among the function
+// literals directly inside scope, in source order. Literals inside nested
+// literals or function declarations are numbered within those instead.
+func literalIndex(scope, lit *ts.Node) int {
+	index := 0
+	var walk func(n *ts.Node) bool
+	walk = func(n *ts.Node) bool {
+		for i := range n.NamedChildCount() {
+			child := n.NamedChild(i)
+			switch child.Kind() {
+			case "function_declaration", "method_declaration":
+				continue
+			case "func_literal":
+				index++
+				if child.Id() == lit.Id() {
+					return true
+				}
+				continue
+			}
+			if walk(child) {
+				return true
+			}
+		}
+		return false
 	}
-	return string(code[nameNode.StartByte():nameNode.EndByte()])
+	walk(scope)
+	return index
+}
+
+func nodeText(code []byte, n *ts.Node) string {
+	return string(code[n.StartByte():n.EndByte()])
 }
 
 // extractFunctionSource returns the full source code of a function node.
@@ -1,6 +1,8 @@
 package directive
 
 import (
+	"maps"
+	"strings"
 	"testing"
 )
 
@@ -61,7 +63,7 @@ func (s *Server) handleRequest() error {
 			expected: []AIDirective{
 				{
 					Comment:   "// @ai handle the request",
-					Function:  "handleRequest",
+					Function:  "(*Server).handleRequest",
 					Source:    "func (s *Server) handleRequest() error {\n\t// @ai handle the request\n\treturn nil\n}",
 					StartLine: 3,
 					EndLine:   6,
@@ -82,7 +84,7 @@ func outer() {
 			expected: []AIDirective{
 				{
 					Comment:   "// @ai implement closure",
-					Function:  "<anonymous>",
+					Function:  "outer.func1",
 					Source:    "func() {\n\t\t// @ai implement closure\n\t}",
 					StartLine: 4,
 					EndLine:   6,
@@ -140,7 +142,7 @@ func (c *Client) connect() error {
 			expected: []AIDirective{
 				{
 					Comment:   "// @ai fix this method",
-					Function:  "connect",
+					Function:  "(*Client).connect",
 					Source:    "func (c *Client) connect() error {\n\treturn nil\n}",
 					StartLine: 4,
 					EndLine:   6,
@@ -194,6 +196,16 @@ func regular() {
 // @ai orphan comment
 
 var x = 1
+`,
+			expected: nil,
+		},
+		{
+			name: "processed @ai-done comment is skipped",
+			code: `package main
+
+func finished() {
+	// @ai-done already handled
+}
 `,
 			expected: nil,
 		},
@@ -232,7 +244,7 @@ func outer() {
 			expected: []AIDirective{
 				{
 					Comment:   "// @ai deeply nested",
-					Function:  "<anonymous>",
+					Function:  "outer.func1.1",
 					Source:    "func() {\n\t\t\t// @ai deeply nested\n\t\t}",
 					StartLine: 5,
 					EndLine:   7,
@@ -284,6 +296,26 @@ func transform(input string, count int) (string, error) {
 				},
 			},
 		},
+		{
+			name: "directive with verb",
+			code: `package main
+
+// @ai:test cover the empty input case
+func parse(s string) int {
+	return len(s)
+}
+`,
+			expected: []AIDirective{
+				{
+					Verb:      VerbTest,
+					Comment:   "// @ai:test cover the empty input case",
+					Function:  "parse",
+					Source:    "func parse(s string) int {\n\treturn len(s)\n}",
+					StartLine: 4,
+					EndLine:   6,
+				},
+			},
+		},
 	}
 
 	parser := NewParser()
@@ -302,6 +334,9 @@ func transform(input string, count int) (string, error) {
 			for i, exp := range tt.expected {
 				got := directives[i]
 
+				if got.Verb != exp.Verb {
+					t.Errorf("directive[%d].Verb: expected %q, got %q", i, exp.Verb, got.Verb)
+				}
 				if got.Comment != exp.Comment {
 					t.Errorf("directive[%d].Comment:\n  expected: %q\n  got:      %q", i, exp.Comment, got.Comment)
 				}
@@ -321,3 +356,221 @@ func transform(input string, count int) (string, error) {
 		})
 	}
 }
+
+func TestAttributes(t *testing.T) {
+	tests := []struct {
+		comment  string
+		expected map[string]string
+	}{
+		{comment: "// @ai add logging", expected: map[string]string{}},
+		{comment: "// @ai-failed(validation, run=1a2b3c4d) add logging", expected: map[string]string{"failed": "validation", "run": "1a2b3c4d"}},
+		{comment: "// @ai-failed(run=1a2b3c4d)", expected: map[string]string{"run": "1a2b3c4d"}},
+		{comment: "// @ai(target=outer) hoist this", expected: map[string]string{"target": "outer"}},
+		{comment: "// @ai:fix(target=outer)-failed(tests, run=ab12)", expected: map[string]string{"target": "outer", "failed": "tests", "run": "ab12"}},
+	}
+	for _, tt := range tests {
+		d := AIDirective{Comment: tt.comment}
+		if got := d.Attributes(); !maps.Equal(got, tt.expected) {
+			t.Errorf("Attributes(%q) = %v, want %v", tt.comment, got, tt.expected)
+		}
+	}
+}
+
+func TestVerbPrompt(t *testing.T) {
+	tests := []struct {
+		comment string
+		verb    Verb
+		prompt  string
+	}{
+		{"// @ai add caching", VerbEdit, "add caching"},
+		{"// @ai:doc", VerbDoc, ""},
+		{"// @ai:review check error paths", VerbReview, "check error paths"},
+		{"// @ai:fix-failed(validation, run=ab12) handle nil", VerbFix, "handle nil"},
+		{"// @ai(target=outer) hoist the closure", VerbEdit, "hoist the closure"},
+	}
+	for _, tt := range tests {
+		d := AIDirective{Comment: tt.comment, Verb: parseVerb(tt.comment)}
+		if d.Verb != tt.verb {
+			t.Errorf("parseVerb(%q) = %q, want %q", tt.comment, d.Verb, tt.verb)
+		}
+		if got, _ := d.Prompt(); got != tt.prompt {
+			t.Errorf("Prompt(%q) = %q, want %q", tt.comment, got, tt.prompt)
+		}
+	}
+}
+
+func TestVerbAfterDocComment(t *testing.T) {
+	code := []byte(`package main
+
+func Foo() {
+	// Foo does things.
+	// @ai:test write tests for Foo
+}
+
+func Bar() {
+	// Bar is read-only here.
+	// @ai:review check error paths
+}
+`)
+	directives, err := NewParser().Parse(code)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Verb{VerbTest, VerbReview}
+	if len(directives) != len(want) {
+		t.Fatalf("expected %d directives, got %d", len(want), len(directives))
+	}
+	for i, verb := range want {
+		if directives[i].Verb != verb {
+			t.Errorf("directive[%d]: expected verb %q, got %q", i, verb, directives[i].Verb)
+		}
+	}
+}
+
+func TestSynthesize(t *testing.T) {
+	code := []byte(`package main
+
+type store struct{}
+
+func (s *store) Get(id string) string {
+	return id
+}
+
+func handler() {
+	fn := func() {
+		println("hi")
+	}
+	fn()
+}
+`)
+	tests := []struct {
+		name     string
+		target   Target
+		verb     Verb
+		function string
+		comment  string
+		start    uint
+		err      bool
+	}{
+		{name: "by name", target: Target{Function: "Get"}, function: "(*store).Get", comment: "// @ai cache lookups", start: 5},
+		{name: "by line", target: Target{Line: 13}, function: "handler", comment: "// @ai cache lookups", start: 9},
+		{name: "innermost by line", target: Target{Line: 11}, function: "handler.func1", comment: "// @ai cache lookups", start: 10},
+		{name: "by qualified name", target: Target{Function: "handler.func1"}, function: "handler.func1", comment: "// @ai cache lookups", start: 10},
+		{name: "with verb", target: Target{Function: "handler"}, verb: VerbTest, function: "handler", comment: "// @ai:test cache lookups", start: 9},
+		{name: "unknown name", target: Target{Function: "Put"}, err: true},
+		{name: "line outside functions", target: Target{Line: 3}, err: true},
+	}
+	parser := NewParser()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := parser.Synthesize(code, tt.target, tt.verb, "cache lookups")
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d.Function != tt.function || d.Comment != tt.comment || d.StartLine != tt.start || d.Verb != tt.verb {
+				t.Errorf("unexpected directive %+v", d)
+			}
+			if prompt, _ := d.Prompt(); prompt != "cache lookups" {
+				t.Errorf("Prompt: expected %q, got %q", "cache lookups", prompt)
+			}
+		})
+	}
+}
+
+func TestQualifiedNames(t *testing.T) {
+	code := []byte(`package main
+
+func init() {
+	_ = func() {}
+}
+
+var handle = func() {
+	// @ai package-level literal
+}
+
+func (s *Service) GetUser() {
+	// @ai service
+}
+
+func (r Repo) GetUser() {
+	// @ai repo
+}
+
+func (l *List[T]) Push(v T) {
+	// @ai generic
+}
+
+func Handler() {
+	a := func() {}
+	b := func() {
+		// @ai second literal
+	}
+	_, _ = a, b
+}
+`)
+	directives, err := NewParser().Parse(code)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []struct{ function, name string }{
+		{"glob..func1", ""},
+		{"(*Service).GetUser", "GetUser"},
+		{"Repo.GetUser", "GetUser"},
+		{"(*List).Push", "Push"},
+		{"Handler.func2", "Handler"},
+	}
+	if len(directives) != len(want) {
+		t.Fatalf("expected %d directives, got %d", len(want), len(directives))
+	}
+	for i, w := range want {
+		if directives[i].Function != w.function || directives[i].Name != w.name {
+			t.Errorf("directive[%d]: expected %s (%q), got %s (%q)", i, w.function, w.name, directives[i].Function, directives[i].Name)
+		}
+	}
+	if directives[1].ID() == directives[2].ID() {
+		t.Error("expected methods with the same name to have different IDs")
+	}
+}
+
+func TestEnclosing(t *testing.T) {
+	code := []byte(`package main
+
+func Serve(names []string) {
+	prefix := "hi "
+	each(names, func(n string) {
+		// @ai also log the prefix
+		println(prefix + n)
+	})
+	each(names, func(n string) {
+		// @ai(target=outer) pass prefix in explicitly
+	})
+}
+`)
+	directives, err := NewParser().Parse(code)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(directives) != 2 {
+		t.Fatalf("expected 2 directives, got %d", len(directives))
+	}
+
+	literal := directives[0]
+	if literal.Function != "Serve.func1" || literal.StartLine != 5 || literal.EndLine != 8 {
+		t.Errorf("expected the literal as target, got %s (lines %d-%d)", literal.Function, literal.StartLine, literal.EndLine)
+	}
+	if e := literal.Enclosing; e == nil || e.Function != "Serve" || e.StartLine != 3 || e.EndLine != 12 || !strings.Contains(e.Source, `prefix := "hi "`) {
+		t.Errorf("expected Serve as enclosing context, got %+v", e)
+	}
+
+	outer := directives[1]
+	if outer.Function != "Serve" || outer.StartLine != 3 || outer.Enclosing != nil {
+		t.Errorf("expected target=outer to retarget Serve, got %s (lines %d-%d, enclosing %v)", outer.Function, outer.StartLine, outer.EndLine, outer.Enclosing)
+	}
+}
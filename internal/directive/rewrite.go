@@ -0,0 +1,117 @@
+package directive
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// markerPattern matches the marker at the start of a directive comment,
+// including any verb and arguments and any "-done" or "-failed(...)" suffix
+// written by a previous run.
+var markerPattern = regexp.MustCompile(`//\s*(@ai(:[a-z]+)?(\([^)]*\))?(?:-done|-failed\([^)]*\))?)`)
+
+// DoneMarker replaces "@ai" in directives that have already been processed.
+const DoneMarker = "@ai-done"
+
+// FailedMarker returns the marker written in place of "@ai" when a directive
+// fails, recording a short reason and the run that produced it. A verb is
+// kept, so "@ai:test" becomes "@ai:test-failed(...)".
+func FailedMarker(reason, runID string) string {
+	reason = strings.NewReplacer("(", "", ")", "", ",", "", "\n", " ").Replace(reason)
+	return fmt.Sprintf("@ai-failed(%s, run=%s)", reason, runID)
+}
+
+// Locate finds the directive in directives that corresponds to orig after the
+// file has been edited. Candidates must carry the same comment text and belong
+// to a function of the same name, ignoring the numbers of function literals;
+// among those, the one whose comment starts closest to the original offset
+// wins.
+func Locate(directives []AIDirective, orig AIDirective) (AIDirective, bool) {
+	var (
+		best     AIDirective
+		bestDist uint
+		found    bool
+	)
+	for _, d := range directives {
+		if d.Comment != orig.Comment || d.stableName() != orig.stableName() {
+			continue
+		}
+		dist := distance(d.CommentStart, orig.CommentStart)
+		if !found || dist < bestDist {
+			best, bestDist, found = d, dist, true
+		}
+	}
+	return best, found
+}
+
+// Strip removes the comment block of d from code. Lines that only held the
+// comment are deleted; a trailing comment after code is cut from its line.
+func Strip(code []byte, d AIDirective) []byte {
+	start, end := d.CommentStart, d.CommentEnd
+
+	lineStart := start
+	for lineStart > 0 && code[lineStart-1] != '\n' {
+		lineStart--
+	}
+	if len(bytes.TrimSpace(code[lineStart:start])) == 0 {
+		start = lineStart
+		if end < uint(len(code)) && code[end] == '\n' {
+			end++
+		}
+	} else {
+		for start > lineStart && (code[start-1] == ' ' || code[start-1] == '\t') {
+			start--
+		}
+	}
+
+	out := make([]byte, 0, len(code)-int(end-start))
+	out = append(out, code[:start]...)
+	return append(out, code[end:]...)
+}
+
+// MarkDone rewrites the "@ai" marker of d as DoneMarker so the parser skips it.
+func MarkDone(code []byte, d AIDirective) []byte {
+	return replaceMarker(code, d, DoneMarker)
+}
+
+// MarkFailed rewrites the marker of d with FailedMarker, replacing any
+// failure marker left by an earlier run.
+func MarkFailed(code []byte, d AIDirective, reason, runID string) []byte {
+	return replaceMarker(code, d, FailedMarker(reason, runID))
+}
+
+// replaceMarker swaps the first marker in d's comment block for marker,
+// keeping any verb and arguments.
+func replaceMarker(code []byte, d AIDirective, marker string) []byte {
+	block := code[d.CommentStart:d.CommentEnd]
+	loc := markerPattern.FindSubmatchIndex(block)
+	if loc == nil {
+		return code
+	}
+	// Everything from "@ai" up to the suffix is kept.
+	keep := loc[2] + len("@ai")
+	if loc[5] >= 0 {
+		keep = loc[5]
+	}
+	if loc[7] >= 0 {
+		keep = loc[7]
+	}
+	marker = string(block[loc[2]:keep]) + strings.TrimPrefix(marker, "@ai")
+
+	// Keep the comment prefix and spacing, swapping only the marker itself.
+	start := int(d.CommentStart) + loc[2]
+	end := int(d.CommentStart) + loc[3]
+	out := make([]byte, 0, len(code)-(end-start)+len(marker))
+	out = append(out, code[:start]...)
+	out = append(out, marker...)
+	return append(out, code[end:]...)
+}
+
+func distance(a, b uint) uint {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,310 @@
+package directive
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocate(t *testing.T) {
+	parser := NewParser()
+	before := []byte(`package main
+
+func first() {
+	// @ai implement
+}
+
+func second() {
+	// @ai implement
+}
+`)
+	after := []byte(`package main
+
+// first now has a doc comment.
+func first() {
+	return
+}
+
+func second() {
+	// @ai implement
+}
+`)
+
+	orig, err := parser.Parse(before)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	current, err := parser.Parse(after)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := Locate(current, orig[0]); ok {
+		t.Error("expected first directive to be gone")
+	}
+	got, ok := Locate(current, orig[1])
+	if !ok {
+		t.Fatal("expected to locate second directive")
+	}
+	if got.StartLine != 8 {
+		t.Errorf("StartLine: expected 8, got %d", got.StartLine)
+	}
+}
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "body comment block",
+			code: `package main
+
+func process() {
+	// @ai implement the function
+	// with multiple lines
+	return
+}
+`,
+			expected: `package main
+
+func process() {
+	return
+}
+`,
+		},
+		{
+			name: "doc-style comment",
+			code: `package main
+
+// @ai implement this function
+func calculate() int {
+	return 0
+}
+`,
+			expected: `package main
+
+func calculate() int {
+	return 0
+}
+`,
+		},
+		{
+			name: "trailing comment after code",
+			code: `package main
+
+func run() {
+	call() // @ai handle the error
+}
+`,
+			expected: `package main
+
+func run() {
+	call()
+}
+`,
+		},
+	}
+
+	parser := NewParser()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directives, err := parser.Parse([]byte(tt.code))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(directives) != 1 {
+				t.Fatalf("expected 1 directive, got %d", len(directives))
+			}
+
+			got := string(Strip([]byte(tt.code), directives[0]))
+			if got != tt.expected {
+				t.Errorf("\n  expected: %q\n  got:      %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMarkDone(t *testing.T) {
+	code := []byte(`package main
+
+func process() {
+	// context line
+	//  @ai implement this
+}
+`)
+	directives, err := NewParser().Parse(code)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := string(MarkDone(code, directives[0]))
+	expected := `package main
+
+func process() {
+	// context line
+	//  @ai-done implement this
+}
+`
+	if got != expected {
+		t.Errorf("\n  expected: %q\n  got:      %q", expected, got)
+	}
+
+	again, err := NewParser().Parse([]byte(got))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(again) != 0 {
+		t.Errorf("expected marked directive to be skipped, got %d", len(again))
+	}
+}
+
+func TestMarkFailed(t *testing.T) {
+	code := []byte(`package main
+
+func process() {
+	// @ai implement this
+}
+`)
+	directives, err := NewParser().Parse(code)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	marked := MarkFailed(code, directives[0], "validation", "ab12")
+	expected := `package main
+
+func process() {
+	// @ai-failed(validation, run=ab12) implement this
+}
+`
+	if string(marked) != expected {
+		t.Errorf("\n  expected: %q\n  got:      %q", expected, string(marked))
+	}
+
+	if again, _ := NewParser().Parse(marked); len(again) != 0 {
+		t.Errorf("expected failed directive to be skipped by default, got %d", len(again))
+	}
+
+	retried, err := NewParser(WithFailed(true)).Parse(marked)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(retried) != 1 {
+		t.Fatalf("expected failed directive to be parsed with WithFailed, got %d", len(retried))
+	}
+	prompt, _ := retried[0].Prompt()
+	if prompt != "implement this" {
+		t.Errorf("Prompt: expected %q, got %q", "implement this", prompt)
+	}
+
+	remarked := string(MarkFailed(marked, retried[0], "tests", "cd34"))
+	expected = `package main
+
+func process() {
+	// @ai-failed(tests, run=cd34) implement this
+}
+`
+	if remarked != expected {
+		t.Errorf("\n  expected: %q\n  got:      %q", expected, remarked)
+	}
+}
+
+func TestMarkKeepsVerb(t *testing.T) {
+	code := []byte(`package main
+
+// @ai:test cover errors
+func process() error {
+	return nil
+}
+`)
+	directives, err := NewParser().Parse(code)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := string(MarkDone(code, directives[0])); !strings.Contains(got, "// @ai:test-done cover errors") {
+		t.Errorf("expected verb kept by MarkDone, got %q", got)
+	}
+
+	marked := MarkFailed(code, directives[0], "tests", "ab12")
+	if !strings.Contains(string(marked), "// @ai:test-failed(tests, run=ab12) cover errors") {
+		t.Errorf("expected verb kept by MarkFailed, got %q", marked)
+	}
+	retried, err := NewParser(WithFailed(true)).Parse(marked)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(retried) != 1 || retried[0].Verb != VerbTest {
+		t.Fatalf("expected retried test directive, got %+v", retried)
+	}
+}
+
+func TestMarkKeepsArguments(t *testing.T) {
+	code := []byte(`package main
+
+func process() {
+	go func() {
+		// @ai(target=outer) use a worker pool
+	}()
+}
+`)
+	directives, err := NewParser().Parse(code)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	marked := string(MarkFailed(code, directives[0], "validation", "ab12"))
+	if !strings.Contains(marked, "// @ai(target=outer)-failed(validation, run=ab12) use a worker pool") {
+		t.Errorf("expected arguments kept by MarkFailed, got %q", marked)
+	}
+	retried, err := NewParser(WithFailed(true)).Parse([]byte(marked))
+	if err != nil || len(retried) != 1 {
+		t.Fatalf("expected the failed directive to be parsed, got %d (%v)", len(retried), err)
+	}
+	if got := string(MarkDone([]byte(marked), retried[0])); !strings.Contains(got, "// @ai(target=outer)-done use a worker pool") {
+		t.Errorf("expected arguments kept by MarkDone, got %q", got)
+	}
+}
+
+func TestLocateLiteral(t *testing.T) {
+	parser := NewParser()
+	before := []byte(`package main
+
+func Handler() {
+	run(func() {
+		// @ai retry on timeout
+	})
+}
+`)
+	// A literal added before the target renumbers it from func1 to func2.
+	after := []byte(`package main
+
+func Handler() {
+	defer func() {}()
+	run(func() {
+		// @ai retry on timeout
+	})
+}
+`)
+
+	orig, err := parser.Parse(before)
+	if err != nil || len(orig) != 1 {
+		t.Fatalf("parsing before: %d directives, %v", len(orig), err)
+	}
+	current, err := parser.Parse(after)
+	if err != nil || len(current) != 1 {
+		t.Fatalf("parsing after: %d directives, %v", len(current), err)
+	}
+	if orig[0].Function != "Handler.func1" || current[0].Function != "Handler.func2" {
+		t.Fatalf("expected the literal to be renumbered, got %s and %s", orig[0].Function, current[0].Function)
+	}
+
+	got, ok := Locate(current, orig[0])
+	if !ok || got.StartLine != 5 {
+		t.Errorf("expected to locate the renumbered literal at line 5, got %v (line %d)", ok, got.StartLine)
+	}
+	if got.ID() != orig[0].ID() {
+		t.Errorf("expected the ID to survive renumbering, got %s and %s", orig[0].ID(), got.ID())
+	}
+}
@@ -0,0 +1,362 @@
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+	"golang.org/x/tools/go/packages"
+)
+
+// Types loads the package of file with type information and returns the
+// declarations of identifiers the target of d uses from other files and
+// packages, with their doc comments, plus the interfaces a method target
+// helps its receiver satisfy. Declarations in file itself are left to
+// SameFile. Symbols are picked in the order the target first uses them until
+// their combined size would exceed budget bytes. Nothing is returned when the
+// target in file on disk no longer matches d, as with unsaved edits.
+func Types(ctx context.Context, file string, d directive.AIDirective, budget int) ([]Symbol, error) {
+	if budget <= 0 {
+		return nil, nil
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	// d may come from an unsaved editor buffer. Its offsets only mean
+	// something in the file on disk if the target is found there unchanged,
+	// and the overlay makes the loader parse exactly those bytes.
+	src, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, err
+	}
+	if d.StartByte > d.EndByte || d.EndByte > uint(len(src)) || string(src[d.StartByte:d.EndByte]) != d.Source {
+		return nil, nil
+	}
+
+	cfg := &packages.Config{
+		Context: ctx,
+		Mode:    packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedImports | packages.NeedDeps,
+		Dir:     filepath.Dir(abs),
+		Overlay: map[string][]byte{abs: src},
+	}
+	pkgs, err := packages.Load(cfg, "file="+abs)
+	if err != nil {
+		return nil, fmt.Errorf("loading package: %w", err)
+	}
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("no package contains %s", file)
+	}
+	pkg := pkgs[0]
+	if len(pkg.Errors) > 0 && pkg.TypesInfo == nil {
+		return nil, fmt.Errorf("loading package: %v", pkg.Errors[0])
+	}
+
+	syntax, tf := targetFile(pkg, abs)
+	if syntax == nil {
+		return nil, fmt.Errorf("%s is not part of package %s", file, pkg.PkgPath)
+	}
+	if int(d.EndByte) > tf.Size() {
+		return nil, nil
+	}
+	start, end := tf.Pos(int(d.StartByte)), tf.Pos(int(d.EndByte))
+
+	r := renderer{fset: pkg.Fset, dir: filepath.Dir(abs), files: map[string]parsedFile{}}
+	var (
+		symbols []Symbol
+		used    int
+		seen    = map[types.Object]bool{}
+	)
+	add := func(obj types.Object) {
+		if seen[obj] {
+			return
+		}
+		seen[obj] = true
+		s, ok := r.symbol(obj)
+		if !ok || used+len(s.Source) > budget {
+			return
+		}
+		used += len(s.Source)
+		symbols = append(symbols, s)
+	}
+
+	for _, obj := range usedObjects(pkg, syntax, start, end) {
+		if pos := pkg.Fset.Position(obj.Pos()); pos.Filename == abs {
+			continue
+		}
+		add(obj)
+	}
+	for _, iface := range satisfiedInterfaces(pkg, syntax, start, end) {
+		add(iface)
+	}
+	return symbols, nil
+}
+
+// targetFile returns the syntax tree and token file of path within pkg.
+func targetFile(pkg *packages.Package, path string) (*ast.File, *token.File) {
+	for _, f := range pkg.Syntax {
+		tf := pkg.Fset.File(f.Pos())
+		if tf != nil && tf.Name() == path {
+			return f, tf
+		}
+	}
+	return nil, nil
+}
+
+// usedObjects returns the package-level objects, methods and types that
+// identifiers between start and end refer to, in order of first use.
+func usedObjects(pkg *packages.Package, f *ast.File, start, end token.Pos) []types.Object {
+	var objs []types.Object
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil || n.End() <= start || n.Pos() >= end {
+			return n == f
+		}
+		id, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		obj := pkg.TypesInfo.Uses[id]
+		if obj == nil || obj.Pkg() == nil {
+			return true // universe scope: builtins and predeclared types
+		}
+		switch obj := obj.(type) {
+		case *types.PkgName, *types.Label:
+			return true
+		case *types.Func:
+			objs = append(objs, obj)
+		case *types.TypeName:
+			objs = append(objs, obj)
+		case *types.Var, *types.Const:
+			// Locals and struct fields are either in the source already or
+			// shown with their type.
+			if obj.Parent() == obj.Pkg().Scope() {
+				objs = append(objs, obj)
+			}
+		}
+		return true
+	})
+	return objs
+}
+
+// satisfiedInterfaces returns the interfaces used by the package that declare
+// the target method and that its receiver type implements, so an edit does
+// not break them.
+func satisfiedInterfaces(pkg *packages.Package, f *ast.File, start, end token.Pos) []types.Object {
+	var method *types.Func
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if ok && fn.Recv != nil && fn.Pos() <= start && end <= fn.End() {
+			method, _ = pkg.TypesInfo.Defs[fn.Name].(*types.Func)
+		}
+	}
+	if method == nil {
+		return nil
+	}
+	recv := method.Signature().Recv().Type()
+
+	var found []types.Object
+	seen := map[types.Object]bool{}
+	consider := func(obj types.Object) {
+		tn, ok := obj.(*types.TypeName)
+		if !ok || seen[tn] {
+			return
+		}
+		seen[tn] = true
+		iface, ok := tn.Type().Underlying().(*types.Interface)
+		if !ok || iface.Empty() {
+			return
+		}
+		if m, _, _ := types.LookupFieldOrMethod(iface, false, nil, method.Name()); m == nil {
+			return
+		}
+		if types.Implements(recv, iface) {
+			found = append(found, tn)
+		}
+	}
+	scope := pkg.Types.Scope()
+	for _, name := range scope.Names() {
+		consider(scope.Lookup(name))
+	}
+	for _, obj := range pkg.TypesInfo.Uses {
+		consider(obj)
+	}
+	return found
+}
+
+// renderer prints declarations from source, parsing each file once.
+type renderer struct {
+	fset  *token.FileSet
+	dir   string
+	files map[string]parsedFile
+}
+
+type parsedFile struct {
+	fset *token.FileSet
+	file *ast.File
+}
+
+// symbol renders obj as a Symbol. Declarations whose source cannot be found
+// are rendered from their type information.
+func (r *renderer) symbol(obj types.Object) (Symbol, bool) {
+	pos := r.fset.Position(obj.Pos())
+	s := Symbol{
+		Kind:      kind(obj),
+		Name:      qualified(obj),
+		File:      r.displayPath(obj, pos.Filename),
+		StartLine: uint(pos.Line),
+		EndLine:   uint(pos.Line),
+	}
+
+	if src, startLine, endLine, ok := r.declSource(pos); ok {
+		s.Source, s.StartLine, s.EndLine = src, startLine, endLine
+		return s, true
+	}
+	if s.Kind == "" {
+		return s, false
+	}
+	s.Source = types.ObjectString(obj, func(p *types.Package) string { return p.Name() })
+	return s, true
+}
+
+// declSource returns the doc comment and declaration at pos, with function
+// bodies left out.
+func (r *renderer) declSource(pos token.Position) (string, uint, uint, bool) {
+	if pos.Filename == "" {
+		return "", 0, 0, false
+	}
+	parsed, ok := r.files[pos.Filename]
+	if !ok {
+		parsed.fset = token.NewFileSet()
+		parsed.file, _ = parser.ParseFile(parsed.fset, pos.Filename, nil, parser.ParseComments|parser.SkipObjectResolution)
+		r.files[pos.Filename] = parsed
+	}
+	fset, f := parsed.fset, parsed.file
+	if f == nil {
+		return "", 0, 0, false
+	}
+	tf := fset.File(f.Pos())
+	at := tf.Pos(pos.Offset)
+
+	var (
+		doc  *ast.CommentGroup
+		node ast.Node
+	)
+	for _, decl := range f.Decls {
+		if decl.Pos() > at || at >= decl.End() {
+			continue
+		}
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			fn := *decl
+			fn.Body, fn.Doc = nil, nil
+			doc, node = decl.Doc, &fn
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if spec.Pos() > at || at >= spec.End() {
+					continue
+				}
+				doc = decl.Doc
+				if len(decl.Specs) > 1 || doc == nil {
+					doc = specDoc(spec)
+				}
+				node = &ast.GenDecl{Tok: decl.Tok, Specs: []ast.Spec{withoutDoc(spec)}}
+			}
+		}
+	}
+	if node == nil {
+		return "", 0, 0, false
+	}
+
+	var buf bytes.Buffer
+	startLine := fset.Position(node.Pos()).Line
+	if doc != nil {
+		startLine = fset.Position(doc.Pos()).Line
+		for _, line := range strings.Split(strings.TrimRight(doc.Text(), "\n"), "\n") {
+			buf.WriteString(strings.TrimRight("// "+line, " ") + "\n")
+		}
+	}
+	if err := printer.Fprint(&buf, fset, node); err != nil {
+		return "", 0, 0, false
+	}
+	return buf.String(), uint(startLine), uint(fset.Position(node.End()).Line), true
+}
+
+func specDoc(spec ast.Spec) *ast.CommentGroup {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		return spec.Doc
+	case *ast.ValueSpec:
+		return spec.Doc
+	}
+	return nil
+}
+
+func withoutDoc(spec ast.Spec) ast.Spec {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		s := *spec
+		s.Doc, s.Comment = nil, nil
+		return &s
+	case *ast.ValueSpec:
+		s := *spec
+		s.Doc, s.Comment = nil, nil
+		return &s
+	}
+	return spec
+}
+
+// displayPath shows files in the target's module relative to its directory
+// and others by import path.
+func (r *renderer) displayPath(obj types.Object, filename string) string {
+	if filename == "" {
+		return obj.Pkg().Path()
+	}
+	if rel, err := filepath.Rel(r.dir, filename); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return obj.Pkg().Path() + "/" + filepath.Base(filename)
+}
+
+func kind(obj types.Object) string {
+	switch obj := obj.(type) {
+	case *types.TypeName:
+		return "type"
+	case *types.Func:
+		if obj.Signature().Recv() != nil {
+			return "method"
+		}
+		return "func"
+	case *types.Const:
+		return "const"
+	case *types.Var:
+		return "var"
+	}
+	return ""
+}
+
+// qualified names obj as it would be written in another package, such as
+// "store.User" or "store.Store.Get".
+func qualified(obj types.Object) string {
+	name := obj.Pkg().Name() + "." + obj.Name()
+	fn, ok := obj.(*types.Func)
+	if !ok || fn.Signature().Recv() == nil {
+		return name
+	}
+	recv := fn.Signature().Recv().Type()
+	if ptr, ok := recv.(*types.Pointer); ok {
+		recv = ptr.Elem()
+	}
+	if named, ok := recv.(*types.Named); ok {
+		return obj.Pkg().Name() + "." + named.Obj().Name() + "." + obj.Name()
+	}
+	return name
+}
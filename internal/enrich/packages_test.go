@@ -0,0 +1,136 @@
+package enrich
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+)
+
+var module = map[string]string{
+	"go.mod": "module example.com/app\n\ngo 1.22\n",
+	"users/validate.go": `package users
+
+import "errors"
+
+// ErrInvalid is returned for users that fail validation.
+var ErrInvalid = errors.New("invalid user")
+
+// UserValidator checks users before they are stored.
+type UserValidator interface {
+	// Validate returns ErrInvalid if u may not be stored.
+	Validate(u User) error
+}
+
+// User is a registered user.
+type User struct {
+	Name string
+}
+
+// NewValidator returns the default validator.
+func NewValidator() UserValidator {
+	return nil
+}
+`,
+	"service/saver.go": `package service
+
+import "example.com/app/users"
+
+// Saver stores users.
+type Saver interface {
+	Save(name string) error
+}
+
+var _ Saver = (*Service)(nil)
+
+type Service struct {
+	v users.UserValidator
+}
+`,
+	"service/service.go": `package service
+
+import "example.com/app/users"
+
+func helper() {}
+
+func (s *Service) Save(name string) error {
+	// @ai use the UserValidator
+	u := users.User{Name: name}
+	if err := s.v.Validate(u); err != nil {
+		return users.ErrInvalid
+	}
+	_ = users.NewValidator()
+	helper()
+	return nil
+}
+`,
+}
+
+func TestTypes(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range module {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "service", "service.go")
+	directives, err := directive.NewParser().Parse([]byte(module["service/service.go"]))
+	if err != nil || len(directives) != 1 {
+		t.Fatalf("parsing source: %d directives, %v", len(directives), err)
+	}
+	d := directives[0]
+
+	symbols, err := Types(context.Background(), file, d, 4096)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, s := range symbols {
+		names = append(names, s.Name)
+	}
+	want := []string{"service.Service", "users.User", "users.UserValidator.Validate", "users.ErrInvalid", "users.NewValidator", "service.Saver"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+
+	byName := map[string]Symbol{}
+	for _, s := range symbols {
+		byName[s.Name] = s
+	}
+	if s := byName["users.NewValidator"]; s.Source != "// NewValidator returns the default validator.\nfunc NewValidator() UserValidator" {
+		t.Errorf("expected the signature without its body, got %q", s.Source)
+	}
+	if s := byName["users.UserValidator.Validate"]; !strings.Contains(s.Source, "Validate(u User) error") || s.Kind != "method" {
+		t.Errorf("expected the interface method, got %+v", s)
+	}
+	if s := byName["service.Service"]; s.File != "saver.go" {
+		t.Errorf("expected a path relative to the package, got %q", s.File)
+	}
+
+	symbols, err = Types(context.Background(), file, d, 0)
+	if err != nil || symbols != nil {
+		t.Errorf("expected nothing with no budget, got %v, %v", symbols, err)
+	}
+
+	// An unsaved buffer longer than the file on disk, or edited inside the
+	// target, leaves the directive's offsets meaningless there.
+	longer := d
+	longer.StartByte += 4096
+	longer.EndByte += 4096
+	edited := d
+	edited.Source = strings.Replace(d.Source, "helper()", "helper2()", 1)
+	for name, d := range map[string]directive.AIDirective{"longer buffer": longer, "edited buffer": edited} {
+		symbols, err := Types(context.Background(), file, d, 4096)
+		if err != nil || symbols != nil {
+			t.Errorf("%s: expected type context to be skipped, got %v, %v", name, symbols, err)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package enrich
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+	ts "github.com/tree-sitter/go-tree-sitter"
+	ts_go "github.com/tree-sitter/tree-sitter-go/bindings/go"
+)
+
+// Symbol is a declaration given to the agent as read-only context.
+type Symbol struct {
+	// Kind is "type", "func", "method", "const" or "var".
+	Kind string
+	// Name is the declared name; methods are qualified by their receiver.
+	Name string
+	// File is where the declaration lives.
+	File string
+	// StartLine and EndLine bound the declaration and its doc comment.
+	StartLine uint
+	EndLine   uint
+	// Source is the declaration with its doc comment.
+	Source string
+}
+
+// declaration is a top-level declaration of the file being enriched.
+type declaration struct {
+	Symbol
+	names    []string
+	receiver string
+	start    uint
+	end      uint
+}
+
+// SameFile returns the top-level declarations in code that the target of d
+// refers to, such as types, its receiver, constants and helper functions, in
+// source order. Declarations are picked in the order the target first refers
+// to them until their combined size would exceed budget bytes.
+func SameFile(file string, code []byte, d directive.AIDirective, budget int) ([]Symbol, error) {
+	if budget <= 0 {
+		return nil, nil
+	}
+
+	parser := ts.NewParser()
+	defer parser.Close()
+	if err := parser.SetLanguage(ts.NewLanguage(ts_go.Language())); err != nil {
+		return nil, fmt.Errorf("setting language: %w", err)
+	}
+	tree := parser.Parse(code, nil)
+	defer tree.Close()
+	root := tree.RootNode()
+
+	// A literal's captured variables and helpers are referenced from its
+	// enclosing function, which is already in the prompt; look only at the
+	// target itself.
+	refs, fields := references(code, root, d.StartByte, d.EndByte)
+
+	var candidates []declaration
+	for _, decl := range declarations(file, code, root) {
+		if decl.start <= d.StartByte && d.EndByte <= decl.end {
+			continue // the target itself, or the function enclosing it
+		}
+		candidates = append(candidates, decl)
+	}
+
+	// Rank each declaration by the first reference to one of its names.
+	rank := func(decl declaration) int {
+		best := -1
+		for _, name := range decl.names {
+			pos, ok := refs[name]
+			if decl.Kind == "method" {
+				if _, recv := refs[decl.receiver]; !recv {
+					continue
+				}
+				pos, ok = fields[name]
+			}
+			if ok && (best < 0 || pos < best) {
+				best = pos
+			}
+		}
+		return best
+	}
+	var referenced []declaration
+	ranks := map[uint]int{}
+	for _, decl := range candidates {
+		if r := rank(decl); r >= 0 {
+			ranks[decl.start] = r
+			referenced = append(referenced, decl)
+		}
+	}
+	slices.SortStableFunc(referenced, func(a, b declaration) int { return ranks[a.start] - ranks[b.start] })
+
+	var picked []declaration
+	used := 0
+	for _, decl := range referenced {
+		if used+len(decl.Source) > budget {
+			continue
+		}
+		used += len(decl.Source)
+		picked = append(picked, decl)
+	}
+	slices.SortFunc(picked, func(a, b declaration) int { return int(a.start) - int(b.start) })
+
+	symbols := make([]Symbol, len(picked))
+	for i, decl := range picked {
+		symbols[i] = decl.Symbol
+	}
+	return symbols, nil
+}
+
+// references returns the identifiers used between start and end, mapped to the
+// order in which each first appears. Field and method selectors are returned
+// separately.
+func references(code []byte, root *ts.Node, start, end uint) (idents, fields map[string]int) {
+	idents, fields = map[string]int{}, map[string]int{}
+	n := 0
+	var walk func(node *ts.Node)
+	walk = func(node *ts.Node) {
+		if node.EndByte() <= start || node.StartByte() >= end {
+			return
+		}
+		switch node.Kind() {
+		case "identifier", "type_identifier":
+			if _, ok := idents[text(code, node)]; !ok {
+				idents[text(code, node)] = n
+				n++
+			}
+		case "field_identifier":
+			if _, ok := fields[text(code, node)]; !ok {
+				fields[text(code, node)] = n
+				n++
+			}
+		}
+		for i := range node.NamedChildCount() {
+			walk(node.NamedChild(i))
+		}
+	}
+	walk(root)
+	return idents, fields
+}
+
+// declarations lists the top-level declarations of the file. Grouped
+// constants and variables are kept whole so iota sequences stay readable.
+func declarations(file string, code []byte, root *ts.Node) []declaration {
+	var decls []declaration
+	for i := range root.NamedChildCount() {
+		node := root.NamedChild(i)
+		decl := declaration{start: node.StartByte(), end: node.EndByte()}
+
+		switch node.Kind() {
+		case "function_declaration":
+			decl.Kind = "func"
+			decl.names = []string{fieldText(code, node, "name")}
+		case "method_declaration":
+			decl.Kind = "method"
+			decl.names = []string{fieldText(code, node, "name")}
+			decl.receiver = receiverName(code, node)
+		case "type_declaration":
+			decl.Kind = "type"
+			decl.names = specNames(code, node, "type_spec", "type_alias")
+		case "const_declaration":
+			decl.Kind = "const"
+			decl.names = specNames(code, node, "const_spec")
+		case "var_declaration":
+			decl.Kind = "var"
+			decl.names = specNames(code, node, "var_spec")
+		default:
+			continue
+		}
+		if len(decl.names) == 0 {
+			continue
+		}
+
+		first := node
+		for prev := node.PrevNamedSibling(); prev != nil && prev.Kind() == "comment" && prev.EndPosition().Row+1 >= first.StartPosition().Row; prev = prev.PrevNamedSibling() {
+			first = prev
+		}
+		decl.Name = strings.Join(decl.names, ", ")
+		if decl.receiver != "" {
+			decl.Name = decl.receiver + "." + decl.Name
+		}
+		decl.File = file
+		decl.StartLine = first.StartPosition().Row + 1
+		decl.EndLine = node.EndPosition().Row + 1
+		decl.Source = string(code[first.StartByte():node.EndByte()])
+		decls = append(decls, decl)
+	}
+	return decls
+}
+
+// specNames returns the names declared by the specs of a declaration, which
+// may be grouped in a list.
+func specNames(code []byte, node *ts.Node, kinds ...string) []string {
+	var names []string
+	var walk func(n *ts.Node)
+	walk = func(n *ts.Node) {
+		for i := range n.NamedChildCount() {
+			child := n.NamedChild(i)
+			if !slices.Contains(kinds, child.Kind()) {
+				walk(child)
+				continue
+			}
+			for j := range child.NamedChildCount() {
+				if name := child.NamedChild(j); child.FieldNameForNamedChild(uint32(j)) == "name" {
+					names = append(names, text(code, name))
+				}
+			}
+		}
+	}
+	walk(node)
+	return names
+}
+
+// receiverName returns the base type name of a method's receiver.
+func receiverName(code []byte, method *ts.Node) string {
+	params := method.ChildByFieldName("receiver")
+	if params == nil || params.NamedChildCount() == 0 {
+		return ""
+	}
+	typ := params.NamedChild(0).ChildByFieldName("type")
+	for typ != nil && typ.Kind() != "type_identifier" {
+		switch typ.Kind() {
+		case "pointer_type":
+			typ = typ.NamedChild(0)
+		case "generic_type":
+			typ = typ.ChildByFieldName("type")
+		default:
+			return ""
+		}
+	}
+	if typ == nil {
+		return ""
+	}
+	return text(code, typ)
+}
+
+func fieldText(code []byte, node *ts.Node, field string) string {
+	if child := node.ChildByFieldName(field); child != nil {
+		return text(code, child)
+	}
+	return ""
+}
+
+func text(code []byte, n *ts.Node) string {
+	return string(code[n.StartByte():n.EndByte()])
+}
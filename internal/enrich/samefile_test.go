@@ -0,0 +1,111 @@
+package enrich
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+)
+
+const source = `package store
+
+import "errors"
+
+// ErrNotFound is returned when a user does not exist.
+var ErrNotFound = errors.New("not found")
+
+const (
+	roleAdmin = iota
+	roleUser
+)
+
+// Store keeps users in memory.
+type Store struct {
+	users map[string]User
+}
+
+type User struct {
+	Name string
+	Role int
+}
+
+type Unrelated struct{}
+
+func (s *Store) lookup(id string) (User, bool) {
+	u, ok := s.users[id]
+	return u, ok
+}
+
+func (u Unrelated) lookup() {}
+
+func normalize(id string) string { return id }
+
+// GetUser returns the user with id.
+func (s *Store) GetUser(id string) (User, error) {
+	// @ai reject admins
+	u, ok := s.lookup(normalize(id))
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+`
+
+func target(t *testing.T) directive.AIDirective {
+	t.Helper()
+	directives, err := directive.NewParser().Parse([]byte(source))
+	if err != nil || len(directives) != 1 {
+		t.Fatalf("parsing source: %d directives, %v", len(directives), err)
+	}
+	return directives[0]
+}
+
+func TestSameFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget int
+		want   []string
+	}{
+		{name: "disabled", budget: 0},
+		{
+			name:   "everything referenced",
+			budget: 4096,
+			want:   []string{"ErrNotFound", "Store", "User", "Store.lookup", "normalize"},
+		},
+		{
+			// Store and User are referenced first; nothing else fits after
+			// them.
+			name:   "budget",
+			budget: 130,
+			want:   []string{"Store", "User"},
+		},
+	}
+	d := target(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			symbols, err := SameFile("store.go", []byte(source), d, tt.budget)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []string
+			for _, s := range symbols {
+				got = append(got, s.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSameFileDocComments(t *testing.T) {
+	symbols, err := SameFile("store.go", []byte(source), target(t), 4096)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store := symbols[1]
+	want := "// Store keeps users in memory.\ntype Store struct {\n\tusers map[string]User\n}"
+	if store.Source != want || store.Kind != "type" || store.StartLine != 13 || store.EndLine != 16 {
+		t.Errorf("unexpected symbol %+v", store)
+	}
+}
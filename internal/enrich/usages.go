@@ -0,0 +1,315 @@
+package enrich
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+	"golang.org/x/mod/modfile"
+)
+
+// Usages returns the functions in the module around file that call the target
+// of d, and the Test functions that reference it, as "caller" and "test"
+// symbols. Calls are matched by name: a function must be called through its
+// package, while a method matches any call of that name in a file of the
+// same package or one importing it. Callers and tests are picked alternately,
+// nearest package first, until their combined size would exceed budget bytes.
+// Function literals have no callers of their own and get none.
+func Usages(file string, d directive.AIDirective, budget int) ([]Symbol, error) {
+	if budget <= 0 || d.Name == "" || d.Enclosing != nil {
+		return nil, nil
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(abs)
+
+	t, err := loadTarget(abs, d)
+	if err != nil || t == nil {
+		return nil, err
+	}
+
+	var callers, tests []usage
+	err = walkModule(t.root, func(path string) error {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), path, src, parser.SkipObjectResolution)
+		if err != nil {
+			return nil // files being edited may not parse; skip them
+		}
+		local := filepath.Dir(path) == dir
+		importName, imported := t.importName(f)
+		if !local && !imported {
+			return nil
+		}
+		isTest := strings.HasSuffix(path, "_test.go")
+
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			start, end := int(fn.Pos())-int(f.FileStart), int(fn.End())-int(f.FileStart)
+			if path == abs && start <= int(d.StartByte) && int(d.EndByte) <= end {
+				continue // the target itself
+			}
+
+			var kind string
+			switch {
+			case isTest && fn.Recv == nil && strings.HasPrefix(fn.Name.Name, "Test") && t.references(fn.Body, local, importName):
+				kind = "test"
+			case t.calls(fn.Body, local, importName):
+				kind = "caller"
+			default:
+				continue
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				rel = path
+			}
+			u := usage{
+				Symbol: Symbol{
+					Kind:      kind,
+					Name:      funcName(fn),
+					File:      rel,
+					StartLine: uint(lineAt(src, start)),
+					EndLine:   uint(lineAt(src, end)),
+					Source:    string(src[start:end]),
+				},
+				path:  path,
+				local: local,
+				start: start,
+			}
+			if kind == "test" {
+				tests = append(tests, u)
+			} else {
+				callers = append(callers, u)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	nearest := func(a, b usage) int {
+		if a.local != b.local {
+			if a.local {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(a.path, b.path); c != 0 {
+			return c
+		}
+		return a.start - b.start
+	}
+	slices.SortFunc(callers, nearest)
+	slices.SortFunc(tests, nearest)
+
+	var pickedCallers, pickedTests []Symbol
+	used := 0
+	pick := func(u usage, picked *[]Symbol) {
+		if used+len(u.Source) > budget {
+			return
+		}
+		used += len(u.Source)
+		*picked = append(*picked, u.Symbol)
+	}
+	for i := range max(len(callers), len(tests)) {
+		if i < len(callers) {
+			pick(callers[i], &pickedCallers)
+		}
+		if i < len(tests) {
+			pick(tests[i], &pickedTests)
+		}
+	}
+	return append(pickedCallers, pickedTests...), nil
+}
+
+// usage is a function that calls or tests the target.
+type usage struct {
+	Symbol
+	path  string
+	local bool
+	start int
+}
+
+// usageTarget describes the function whose usages are wanted.
+type usageTarget struct {
+	name   string
+	method bool
+	// root is the module root and importPath the target's package path.
+	// Outside a module, root is the package directory and importPath empty.
+	root       string
+	importPath string
+}
+
+// loadTarget finds the module around file and whether d is a method. It
+// returns nil if d is not a declared function of file.
+func loadTarget(file string, d directive.AIDirective) (*usageTarget, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	t := &usageTarget{name: d.Name, root: filepath.Dir(file)}
+	found := false
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if ok && int(fn.Pos())-int(f.FileStart) == int(d.StartByte) && fn.Name.Name == d.Name {
+			t.method, found = fn.Recv != nil, true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	for dir := filepath.Dir(file); ; dir = filepath.Dir(dir) {
+		data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			rel, err := filepath.Rel(dir, filepath.Dir(file))
+			if err != nil {
+				return nil, err
+			}
+			t.root = dir
+			t.importPath = modfile.ModulePath(data)
+			if rel != "." {
+				t.importPath += "/" + filepath.ToSlash(rel)
+			}
+			break
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	return t, nil
+}
+
+// importName returns the name f refers to the target's package by, and
+// whether f imports it at all.
+func (t *usageTarget) importName(f *ast.File) (string, bool) {
+	if t.importPath == "" {
+		return "", false
+	}
+	for _, imp := range f.Imports {
+		if path, _ := strconv.Unquote(imp.Path.Value); path != t.importPath {
+			continue
+		}
+		if imp.Name != nil {
+			return imp.Name.Name, true
+		}
+		return filepath.Base(t.importPath), true
+	}
+	return "", false
+}
+
+// calls reports whether body calls the target. local says whether body is in
+// the target's package; otherwise the package is imported as importName.
+func (t *usageTarget) calls(body ast.Node, local bool, importName string) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && t.matches(call.Fun, local, importName) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// references reports whether body mentions the target, called or not, as
+// tests often pass functions around in tables.
+func (t *usageTarget) references(body ast.Node, local bool, importName string) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		if expr, ok := n.(ast.Expr); ok && t.matches(expr, local, importName) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+func (t *usageTarget) matches(expr ast.Expr, local bool, importName string) bool {
+	switch expr := ast.Unparen(expr).(type) {
+	case *ast.Ident:
+		return local && !t.method && expr.Name == t.name
+	case *ast.SelectorExpr:
+		if expr.Sel.Name != t.name {
+			return false
+		}
+		if t.method {
+			return true
+		}
+		x, ok := expr.X.(*ast.Ident)
+		return ok && importName != "" && x.Name == importName
+	}
+	return false
+}
+
+// walkModule calls fn for every Go file under root, skipping nested modules,
+// vendor and testdata directories, and directories the go tool ignores.
+func walkModule(root string, fn func(path string) error) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path == root {
+				return nil
+			}
+			name := entry.Name()
+			if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+		return fn(path)
+	})
+}
+
+// funcName names a function declaration like the directive parser does, with
+// methods qualified by their receiver type.
+func funcName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return fn.Name.Name
+	}
+	typ := fn.Recv.List[0].Type
+	pointer := false
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ, pointer = star.X, true
+	}
+	switch t := typ.(type) {
+	case *ast.IndexExpr:
+		typ = t.X
+	case *ast.IndexListExpr:
+		typ = t.X
+	}
+	recv := "?"
+	if id, ok := typ.(*ast.Ident); ok {
+		recv = id.Name
+	}
+	if pointer {
+		return "(*" + recv + ")." + fn.Name.Name
+	}
+	return recv + "." + fn.Name.Name
+}
+
+func lineAt(src []byte, offset int) int {
+	return 1 + strings.Count(string(src[:offset]), "\n")
+}
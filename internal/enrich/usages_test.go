@@ -0,0 +1,132 @@
+package enrich
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+)
+
+var usagesModule = map[string]string{
+	"go.mod": "module example.com/app\n\ngo 1.22\n",
+	"store/store.go": `package store
+
+type Store struct{ users map[string]string }
+
+func Normalize(id string) string {
+	// @ai trim spaces too
+	return id
+}
+
+func (s *Store) Get(id string) string {
+	// @ai return an error for missing users
+	return s.users[Normalize(id)]
+}
+`,
+	"store/store_test.go": `package store
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	for _, f := range []func(string) string{Normalize} {
+		_ = f("a")
+	}
+}
+
+func TestGet(t *testing.T) {
+	var s Store
+	_ = s.Get("a")
+}
+`,
+	"api/handler.go": `package api
+
+import st "example.com/app/store"
+
+func Handle(s *st.Store, id string) string {
+	return s.Get(st.Normalize(id))
+}
+
+func Normalize(id string) string { return id }
+
+func unrelated() { _ = Normalize("x") }
+`,
+	"other/other.go": `package other
+
+type cache struct{}
+
+func (cache) Get(string) string { return "" }
+
+func use(c cache) { _ = c.Get("a") }
+`,
+	"testdata/fixture.go": `package fixture
+
+import "example.com/app/store"
+
+func fixture() { _ = store.Normalize("a") }
+`,
+}
+
+func TestUsages(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range usagesModule {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "store", "store.go")
+	directives, err := directive.NewParser().Parse([]byte(usagesModule["store/store.go"]))
+	if err != nil || len(directives) != 2 {
+		t.Fatalf("parsing source: %d directives, %v", len(directives), err)
+	}
+
+	tests := []struct {
+		name   string
+		d      directive.AIDirective
+		budget int
+		want   []string
+	}{
+		{
+			name:   "function",
+			d:      directives[0],
+			budget: 4096,
+			want:   []string{"caller (*Store).Get store.go", "caller Handle ../api/handler.go", "test TestNormalize store_test.go"},
+		},
+		{
+			name:   "method",
+			d:      directives[1],
+			budget: 4096,
+			want:   []string{"caller Handle ../api/handler.go", "test TestGet store_test.go"},
+		},
+		{
+			name:   "budget alternates callers and tests",
+			d:      directives[0],
+			budget: 220,
+			want:   []string{"caller (*Store).Get store.go", "test TestNormalize store_test.go"},
+		},
+		{
+			name: "disabled",
+			d:    directives[0],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			symbols, err := Usages(file, tt.d, tt.budget)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []string
+			for _, s := range symbols {
+				got = append(got, s.Kind+" "+s.Name+" "+s.File)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
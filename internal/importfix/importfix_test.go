@@ -0,0 +1,155 @@
+package importfix
+
+import (
+	"testing"
+)
+
+func TestFix(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		before   string
+		expected string
+		added    []string
+		removed  int
+	}{
+		{
+			name: "marker on its own line",
+			src: `package main
+
+func greet(name string) string {
+	// TODO: add strings
+	return strings.ToUpper(name)
+}
+`,
+			expected: `package main
+
+import "strings"
+
+func greet(name string) string {
+	return strings.ToUpper(name)
+}
+`,
+			removed: 1,
+		},
+		{
+			name: "trailing marker with full import path",
+			src: `package main
+
+func handler() {
+	_ = http.StatusOK // TODO: add net/http
+}
+`,
+			expected: `package main
+
+import "net/http"
+
+func handler() {
+	_ = http.StatusOK
+}
+`,
+			added:   []string{"net/http"},
+			removed: 1,
+		},
+		{
+			name: "unused import removed",
+			src: `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println("hi")
+}
+`,
+			expected: `package main
+
+import (
+	"fmt"
+)
+
+func main() {
+	fmt.Println("hi")
+}
+`,
+		},
+		{
+			name: "ordinary TODO left alone",
+			src: `package main
+
+func main() {
+	// TODO: add more tests
+}
+`,
+			expected: `package main
+
+func main() {
+	// TODO: add more tests
+}
+`,
+		},
+		{
+			name: "TODO naming something other than a package left alone",
+			src: `package main
+
+func main() {
+	// TODO: add logging
+}
+`,
+			expected: `package main
+
+func main() {
+	// TODO: add logging
+}
+`,
+		},
+		{
+			name: "marker present before the edit left alone",
+			before: `package main
+
+// TODO: add github.com/acme/metrics
+func greet(name string) string {
+	return name
+}
+`,
+			src: `package main
+
+// TODO: add github.com/acme/metrics
+func greet(name string) string {
+	// TODO: add strings
+	return strings.ToUpper(name)
+}
+`,
+			expected: `package main
+
+import "strings"
+
+// TODO: add github.com/acme/metrics
+func greet(name string) string {
+	return strings.ToUpper(name)
+}
+`,
+			removed: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, res, err := Fix("main.go", []byte(tt.src), Since([]byte(tt.before)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(out) != tt.expected {
+				t.Errorf("output:\n  expected: %q\n  got:      %q", tt.expected, string(out))
+			}
+			if len(res.Added) != len(tt.added) {
+				t.Errorf("added: expected %v, got %v", tt.added, res.Added)
+			}
+			if res.Removed != tt.removed {
+				t.Errorf("removed: expected %d, got %d", tt.removed, res.Removed)
+			}
+		})
+	}
+}
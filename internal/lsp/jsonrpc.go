@@ -0,0 +1,176 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// JSON-RPC error codes used by the server.
+const (
+	codeParseError     = -32700
+	codeInvalidParams  = -32602
+	codeMethodNotFound = -32601
+	codeRequestFailed  = -32803
+)
+
+// message is any JSON-RPC message: a request when Method and ID are set, a
+// notification when only Method is set, and a response otherwise.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *responseError  `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// conn reads and writes Content-Length framed JSON-RPC messages and matches
+// responses to requests sent by the server.
+type conn struct {
+	r *textproto.Reader
+
+	mu sync.Mutex
+	w  io.Writer
+
+	pendingMu sync.Mutex
+	nextID    int
+	pending   map[string]chan *message
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{
+		r:       textproto.NewReader(bufio.NewReader(r)),
+		w:       w,
+		pending: map[string]chan *message{},
+	}
+}
+
+// read returns the next message from the client.
+func (c *conn) read() (*message, error) {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %w", err)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, &responseError{Code: codeParseError, Message: err.Error()}
+	}
+	return &msg, nil
+}
+
+func (c *conn) write(v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(body)
+	return err
+}
+
+// notify sends a notification to the client.
+func (c *conn) notify(method string, params any) error {
+	return c.write(struct {
+		JSONRPC string `json:"jsonrpc"`
+		Method  string `json:"method"`
+		Params  any    `json:"params"`
+	}{"2.0", method, params})
+}
+
+// reply answers the request with id.
+func (c *conn) reply(id json.RawMessage, result any) error {
+	return c.write(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  any             `json:"result"`
+	}{"2.0", id, result})
+}
+
+// replyError fails the request with id.
+func (c *conn) replyError(id json.RawMessage, err *responseError) error {
+	return c.write(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Error   *responseError  `json:"error"`
+	}{"2.0", id, err})
+}
+
+// call sends a request to the client and waits for its response. The read
+// loop must be running in another goroutine to deliver it.
+func (c *conn) call(done <-chan struct{}, method string, params any) (json.RawMessage, error) {
+	c.pendingMu.Lock()
+	c.nextID++
+	id := strconv.Itoa(c.nextID)
+	ch := make(chan *message, 1)
+	c.pending[id] = ch
+	c.pendingMu.Unlock()
+
+	defer func() {
+		c.pendingMu.Lock()
+		delete(c.pending, id)
+		c.pendingMu.Unlock()
+	}()
+
+	err := c.write(struct {
+		JSONRPC string `json:"jsonrpc"`
+		ID      string `json:"id"`
+		Method  string `json:"method"`
+		Params  any    `json:"params"`
+	}{"2.0", id, method, params})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-done:
+		return nil, fmt.Errorf("%s: connection closed", method)
+	case rsp := <-ch:
+		if rsp.Error != nil {
+			return nil, rsp.Error
+		}
+		return rsp.Result, nil
+	}
+}
+
+// deliver hands a response from the client to the call waiting for it.
+func (c *conn) deliver(msg *message) {
+	var id string
+	if err := json.Unmarshal(msg.ID, &id); err != nil {
+		id = string(msg.ID)
+	}
+
+	c.pendingMu.Lock()
+	ch, ok := c.pending[id]
+	c.pendingMu.Unlock()
+	if ok {
+		ch <- msg
+	}
+}
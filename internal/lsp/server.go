@@ -0,0 +1,503 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/runner"
+)
+
+// Commands offered through code actions. Each takes the document URI as its
+// first argument; run and preview also take a directive ID.
+const (
+	CommandRun     = "chisel.run"
+	CommandRunAll  = "chisel.runAll"
+	CommandPreview = "chisel.preview"
+)
+
+// diagnosticSource labels everything the server publishes.
+const diagnosticSource = "chisel"
+
+// Job describes directives the server wants run.
+type Job struct {
+	File string
+	// IDs selects directives by ID; empty runs every directive in File.
+	IDs []string
+	// Report is called before each directive with the number already processed.
+	Report func(done, total int, d directive.AIDirective)
+}
+
+// RunFunc processes the directives selected by job against the file on disk.
+type RunFunc func(ctx context.Context, job Job) ([]runner.Result, error)
+
+// PreviewFunc renders the prompt that would be sent for d.
+type PreviewFunc func(file string, d directive.AIDirective) (string, error)
+
+// Server answers LSP requests for Go files containing @ai directives.
+type Server struct {
+	parser  *directive.Parser
+	run     RunFunc
+	preview PreviewFunc
+
+	conn *conn
+	ctx  context.Context
+	done chan struct{}
+
+	mu               sync.Mutex
+	docs             map[string]string
+	jobs             map[string]context.CancelFunc
+	progressToken    int
+	workDoneProgress bool
+	showDocument     bool
+}
+
+// NewServer creates a Server that finds directives with parser and runs them
+// with run.
+func NewServer(parser *directive.Parser, run RunFunc, preview PreviewFunc) *Server {
+	return &Server{
+		parser:  parser,
+		run:     run,
+		preview: preview,
+		docs:    map[string]string{},
+		jobs:    map[string]context.CancelFunc{},
+	}
+}
+
+// Serve speaks LSP over r and w until the client sends exit or closes the
+// stream. Running jobs are cancelled when it returns.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.conn = newConn(r, w)
+	s.ctx = ctx
+	s.done = make(chan struct{})
+	defer close(s.done)
+
+	for {
+		msg, err := s.conn.read()
+		if err != nil {
+			var rpcErr *responseError
+			if errors.As(err, &rpcErr) {
+				s.conn.replyError(nil, rpcErr)
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		switch {
+		case msg.Method == "exit":
+			return nil
+		case msg.Method == "":
+			s.conn.deliver(msg)
+		case msg.ID == nil:
+			s.handleNotification(msg)
+		default:
+			result, err := s.handleRequest(msg)
+			if err != nil {
+				s.conn.replyError(msg.ID, err)
+				continue
+			}
+			s.conn.reply(msg.ID, result)
+		}
+	}
+}
+
+func (s *Server) handleRequest(msg *message) (any, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		var params InitializeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		s.mu.Lock()
+		s.workDoneProgress = params.Capabilities.Window.WorkDoneProgress
+		s.showDocument = params.Capabilities.Window.ShowDocument.Support
+		s.mu.Unlock()
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:       TextDocumentSyncOptions{OpenClose: true, Change: syncFull, Save: SaveOptions{IncludeText: true}},
+				CodeActionProvider:     true,
+				ExecuteCommandProvider: ExecuteCommandOptions{Commands: []string{CommandRun, CommandRunAll, CommandPreview}},
+			},
+			ServerInfo: ServerInfo{Name: "chisel"},
+		}, nil
+
+	case "shutdown":
+		s.mu.Lock()
+		for _, cancel := range s.jobs {
+			cancel()
+		}
+		s.mu.Unlock()
+		return nil, nil
+
+	case "textDocument/codeAction":
+		var params CodeActionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.codeActions(params), nil
+
+	case "workspace/executeCommand":
+		var params ExecuteCommandParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return nil, s.executeCommand(params)
+
+	default:
+		return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+}
+
+func (s *Server) handleNotification(msg *message) {
+	switch msg.Method {
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			s.setDocument(params.TextDocument.URI, params.TextDocument.Text)
+		}
+
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) == nil && len(params.ContentChanges) > 0 {
+			s.setDocument(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+
+	case "textDocument/didSave":
+		var params DidSaveTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) == nil && params.Text != nil {
+			s.setDocument(params.TextDocument.URI, *params.Text)
+		}
+
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			s.mu.Lock()
+			delete(s.docs, params.TextDocument.URI)
+			s.mu.Unlock()
+			s.publish(params.TextDocument.URI, nil)
+		}
+	}
+}
+
+// setDocument records the latest text of uri and republishes its diagnostics.
+func (s *Server) setDocument(uri, text string) {
+	s.mu.Lock()
+	s.docs[uri] = text
+	s.mu.Unlock()
+	s.publish(uri, s.diagnostics(text))
+}
+
+func (s *Server) publish(uri string, diagnostics []Diagnostic) {
+	if diagnostics == nil {
+		diagnostics = []Diagnostic{}
+	}
+	s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+}
+
+// diagnostics returns an informational diagnostic for each directive in text.
+func (s *Server) diagnostics(text string) []Diagnostic {
+	directives, err := s.parser.Parse([]byte(text))
+	if err != nil {
+		return nil
+	}
+
+	var diagnostics []Diagnostic
+	for _, d := range directives {
+		diagnostics = append(diagnostics, diagnostic(text, d))
+	}
+	return diagnostics
+}
+
+func diagnostic(text string, d directive.AIDirective) Diagnostic {
+	prompt, _ := d.Prompt()
+	return Diagnostic{
+		Range:    commentRange(text, d),
+		Severity: SeverityInformation,
+		Code:     d.ID(),
+		Source:   diagnosticSource,
+		Message:  fmt.Sprintf("@ai directive for %s: %s", d.Function, prompt),
+	}
+}
+
+func commentRange(text string, d directive.AIDirective) Range {
+	return Range{Start: positionAt(text, d.CommentStart), End: positionAt(text, d.CommentEnd)}
+}
+
+// text returns the open buffer for uri, falling back to the file on disk.
+func (s *Server) text(uri string) (string, bool) {
+	s.mu.Lock()
+	text, ok := s.docs[uri]
+	s.mu.Unlock()
+	if ok {
+		return text, true
+	}
+	code, err := os.ReadFile(uriToPath(uri))
+	if err != nil {
+		return "", false
+	}
+	return string(code), true
+}
+
+// codeActions offers to run or preview the directives whose comment or
+// function covers the start of the requested range, and to run every
+// directive in the file.
+func (s *Server) codeActions(params CodeActionParams) []CodeAction {
+	uri := params.TextDocument.URI
+	text, ok := s.text(uri)
+	if !ok {
+		return nil
+	}
+	directives, err := s.parser.Parse([]byte(text))
+	if err != nil || len(directives) == 0 {
+		return nil
+	}
+
+	actions := []CodeAction{}
+	line := params.Range.Start.Line
+	for _, d := range directives {
+		start := positionAt(text, d.CommentStart).Line
+		end := int(d.EndLine) - 1
+		if line < start || line > end {
+			continue
+		}
+		diag := diagnostic(text, d)
+		actions = append(actions,
+			CodeAction{
+				Title:       "Run @ai directive in " + d.Function,
+				Kind:        "refactor.rewrite",
+				Diagnostics: []Diagnostic{diag},
+				Command:     &Command{Title: "Run @ai directive", Command: CommandRun, Arguments: []string{uri, d.ID()}},
+			},
+			CodeAction{
+				Title:   "Preview @ai prompt for " + d.Function,
+				Kind:    "refactor",
+				Command: &Command{Title: "Preview @ai prompt", Command: CommandPreview, Arguments: []string{uri, d.ID()}},
+			},
+		)
+	}
+
+	title := "Run the @ai directive in this file"
+	if len(directives) > 1 {
+		title = fmt.Sprintf("Run all %d @ai directives in this file", len(directives))
+	}
+	actions = append(actions, CodeAction{
+		Title:   title,
+		Kind:    "source",
+		Command: &Command{Title: title, Command: CommandRunAll, Arguments: []string{uri}},
+	})
+	return actions
+}
+
+func (s *Server) executeCommand(params ExecuteCommandParams) *responseError {
+	if len(params.Arguments) < 1 {
+		return &responseError{Code: codeInvalidParams, Message: params.Command + " requires a document URI"}
+	}
+	uri := params.Arguments[0]
+
+	switch params.Command {
+	case CommandRunAll:
+		go s.runJob(uri, nil)
+		return nil
+	case CommandRun, CommandPreview:
+		if len(params.Arguments) < 2 {
+			return &responseError{Code: codeInvalidParams, Message: params.Command + " requires a directive ID"}
+		}
+		if params.Command == CommandPreview {
+			return s.showPreview(uri, params.Arguments[1])
+		}
+		go s.runJob(uri, params.Arguments[1:2])
+		return nil
+	default:
+		return &responseError{Code: codeInvalidParams, Message: "unknown command: " + params.Command}
+	}
+}
+
+// showPreview opens the prompt that would be sent for the directive with id.
+func (s *Server) showPreview(uri, id string) *responseError {
+	text, ok := s.text(uri)
+	if !ok {
+		return &responseError{Code: codeRequestFailed, Message: "cannot read " + uri}
+	}
+	d, ok := s.find(text, id)
+	if !ok {
+		return &responseError{Code: codeRequestFailed, Message: "directive " + id + " not found"}
+	}
+	prompt, err := s.preview(uriToPath(uri), d)
+	if err != nil {
+		return &responseError{Code: codeRequestFailed, Message: err.Error()}
+	}
+
+	s.mu.Lock()
+	showDocument := s.showDocument
+	s.mu.Unlock()
+	if !showDocument {
+		s.showMessage(MessageInfo, prompt)
+		return nil
+	}
+
+	f, err := os.CreateTemp("", "chisel-preview-*.md")
+	if err != nil {
+		return &responseError{Code: codeRequestFailed, Message: err.Error()}
+	}
+	defer f.Close()
+	if _, err := f.WriteString(prompt); err != nil {
+		return &responseError{Code: codeRequestFailed, Message: err.Error()}
+	}
+	// The client answers showDocument through the read loop, so don't wait here.
+	go s.conn.call(s.done, "window/showDocument", ShowDocumentParams{URI: pathToURI(f.Name()), TakeFocus: true})
+	return nil
+}
+
+// find returns the directive in text with id.
+func (s *Server) find(text, id string) (directive.AIDirective, bool) {
+	directives, err := s.parser.Parse([]byte(text))
+	if err != nil {
+		return directive.AIDirective{}, false
+	}
+	for _, d := range directives {
+		if d.ID() == id {
+			return d, true
+		}
+	}
+	return directive.AIDirective{}, false
+}
+
+// runJob runs the directives selected by ids in the file behind uri,
+// reporting progress to the client. It refuses to run while the buffer has
+// unsaved changes, since the agent edits the file on disk.
+func (s *Server) runJob(uri string, ids []string) {
+	path := uriToPath(uri)
+	code, err := os.ReadFile(path)
+	if err != nil {
+		s.showMessage(MessageError, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	buffer, open := s.docs[uri]
+	_, running := s.jobs[uri]
+	s.mu.Unlock()
+	if open && buffer != string(code) {
+		s.showMessage(MessageWarning, "Save "+path+" before running @ai directives.")
+		return
+	}
+	if running {
+		s.showMessage(MessageWarning, "@ai directives are already running in "+path+".")
+		return
+	}
+	for _, id := range ids {
+		if _, ok := s.find(string(code), id); !ok {
+			s.showMessage(MessageWarning, "The @ai directive is no longer in "+path+".")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	s.mu.Lock()
+	s.jobs[uri] = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.jobs, uri)
+		s.mu.Unlock()
+	}()
+
+	progress := s.beginProgress("chisel: running @ai directives")
+	results, err := s.run(ctx, Job{
+		File: path,
+		IDs:  ids,
+		Report: func(done, total int, d directive.AIDirective) {
+			progress.report(fmt.Sprintf("%s (%d/%d)", d.Function, done+1, total), done*100/max(total, 1))
+		},
+	})
+
+	summary := summarize(results)
+	progress.end(summary)
+	switch {
+	case err != nil && ctx.Err() == nil:
+		s.showMessage(MessageError, "chisel: "+err.Error())
+	case runner.Failed(results) > 0:
+		s.showMessage(MessageWarning, "chisel: "+summary)
+	}
+
+	if code, err := os.ReadFile(path); err == nil {
+		s.publish(uri, s.diagnostics(string(code)))
+	}
+}
+
+// summarize describes results in one line.
+func summarize(results []runner.Result) string {
+	var succeeded, failed int
+	for _, res := range results {
+		switch res.Status {
+		case runner.StatusSucceeded:
+			succeeded++
+		case runner.StatusFailed:
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d succeeded, %d failed", succeeded, failed)
+}
+
+func (s *Server) showMessage(typ int, text string) {
+	s.conn.notify("window/showMessage", ShowMessageParams{Type: typ, Message: text})
+}
+
+// progress reports work done on a token created with the client. A progress
+// with no token discards reports, for clients that don't support them.
+type progress struct {
+	conn  *conn
+	token string
+}
+
+// beginProgress creates a progress token with the client and reports the
+// start of work titled title.
+func (s *Server) beginProgress(title string) *progress {
+	s.mu.Lock()
+	supported := s.workDoneProgress
+	s.progressToken++
+	token := fmt.Sprintf("chisel-%d", s.progressToken)
+	s.mu.Unlock()
+
+	p := &progress{conn: s.conn}
+	if !supported {
+		return p
+	}
+	if _, err := s.conn.call(s.done, "window/workDoneProgress/create", WorkDoneProgressCreateParams{Token: token}); err != nil {
+		return p
+	}
+	p.token = token
+	p.send(WorkDoneProgressBegin{Kind: "begin", Title: title})
+	return p
+}
+
+func (p *progress) report(message string, percentage int) {
+	p.send(WorkDoneProgressReport{Kind: "report", Message: message, Percentage: percentage})
+}
+
+func (p *progress) end(message string) {
+	p.send(WorkDoneProgressEnd{Kind: "end", Message: message})
+}
+
+func (p *progress) send(value any) {
+	if p.token == "" {
+		return
+	}
+	p.conn.notify("$/progress", ProgressParams{Token: p.token, Value: value})
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{Code: codeInvalidParams, Message: err.Error()}
+}
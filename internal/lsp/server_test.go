@@ -0,0 +1,172 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/runner"
+)
+
+const source = `package main
+
+// @ai add error handling
+func doSomething() error {
+	return nil
+}
+
+func other() {}
+`
+
+// client drives a Server over in-memory pipes.
+type client struct {
+	t    *testing.T
+	conn *conn
+}
+
+func startServer(t *testing.T) *client {
+	t.Helper()
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+
+	noRun := func(ctx context.Context, job Job) ([]runner.Result, error) { return nil, nil }
+	preview := func(file string, d directive.AIDirective) (string, error) { return "", nil }
+	s := NewServer(directive.NewParser(), noRun, preview)
+
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(context.Background(), serverR, serverW) }()
+	t.Cleanup(func() {
+		clientW.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return &client{t: t, conn: newConn(clientR, clientW)}
+}
+
+// request sends a request and returns the server's response, skipping any
+// notifications sent before it.
+func (c *client) request(id int, method string, params any, result any) {
+	c.t.Helper()
+	if err := c.conn.write(map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params}); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		msg := c.read()
+		if msg.Method != "" {
+			continue
+		}
+		if string(msg.ID) != fmt.Sprint(id) {
+			c.t.Fatalf("response for %s has id %s", method, msg.ID)
+		}
+		if msg.Error != nil {
+			c.t.Fatalf("%s: %v", method, msg.Error)
+		}
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			c.t.Fatal(err)
+		}
+		return
+	}
+}
+
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+	if err := c.conn.notify(method, params); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) read() *message {
+	c.t.Helper()
+	msg, err := c.conn.read()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+func TestServerDiagnosticsAndCodeActions(t *testing.T) {
+	c := startServer(t)
+
+	var init InitializeResult
+	c.request(1, "initialize", map[string]any{"rootUri": "file:///tmp"}, &init)
+	if !init.Capabilities.CodeActionProvider {
+		t.Fatal("expected code action support")
+	}
+
+	uri := "file:///tmp/main.go"
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "go", Version: 1, Text: source},
+	})
+
+	msg := c.read()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		t.Fatalf("expected diagnostics, got %s", msg.Method)
+	}
+	var published PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &published); err != nil {
+		t.Fatal(err)
+	}
+	if len(published.Diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %d", len(published.Diagnostics))
+	}
+	diag := published.Diagnostics[0]
+	wantRange := Range{Start: Position{Line: 2, Character: 0}, End: Position{Line: 2, Character: 25}}
+	if diag.Range != wantRange {
+		t.Errorf("expected range %+v, got %+v", wantRange, diag.Range)
+	}
+	if diag.Severity != SeverityInformation {
+		t.Errorf("expected information severity, got %d", diag.Severity)
+	}
+	if diag.Message != "@ai directive for doSomething: add error handling" {
+		t.Errorf("unexpected message %q", diag.Message)
+	}
+
+	tests := []struct {
+		name     string
+		line     int
+		commands []string
+	}{
+		{name: "on comment", line: 2, commands: []string{CommandRun, CommandPreview, CommandRunAll}},
+		{name: "in function", line: 4, commands: []string{CommandRun, CommandPreview, CommandRunAll}},
+		{name: "outside function", line: 7, commands: []string{CommandRunAll}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actions []CodeAction
+			c.request(i+2, "textDocument/codeAction", CodeActionParams{
+				TextDocument: TextDocumentIdentifier{URI: uri},
+				Range:        Range{Start: Position{Line: tt.line}, End: Position{Line: tt.line}},
+			}, &actions)
+
+			var commands []string
+			for _, a := range actions {
+				commands = append(commands, a.Command.Command)
+			}
+			if fmt.Sprint(commands) != fmt.Sprint(tt.commands) {
+				t.Errorf("expected commands %v, got %v", tt.commands, commands)
+			}
+		})
+	}
+}
+
+func TestPositionAt(t *testing.T) {
+	text := "a\nb😀c\n"
+	tests := []struct {
+		offset uint
+		want   Position
+	}{
+		{offset: 0, want: Position{Line: 0, Character: 0}},
+		{offset: 2, want: Position{Line: 1, Character: 0}},
+		{offset: 7, want: Position{Line: 1, Character: 3}},
+		{offset: 100, want: Position{Line: 2, Character: 0}},
+	}
+	for _, tt := range tests {
+		if got := positionAt(text, tt.offset); got != tt.want {
+			t.Errorf("positionAt(%d) = %+v, want %+v", tt.offset, got, tt.want)
+		}
+	}
+}
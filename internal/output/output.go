@@ -0,0 +1,196 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Format selects how chisel reports progress.
+type Format string
+
+const (
+	// FormatText prints colored, human-readable output.
+	FormatText Format = "text"
+	// FormatNDJSON emits one JSON Event per line for editor integrations.
+	FormatNDJSON Format = "ndjson"
+)
+
+// ParseFormat validates a --output value.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatText, FormatNDJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q (want text or ndjson)", s)
+	}
+}
+
+// Event types emitted in NDJSON mode.
+const (
+	TypeRunStarted          = "run.started"
+	TypeDirectiveStarted    = "directive.started"
+	TypeDirectiveRepair     = "directive.repair"
+	TypeDirectiveFinished   = "directive.finished"
+	TypeTextDelta           = "text.delta"
+	TypeTool                = "tool"
+	TypePermissionRequested = "permission.requested"
+	TypePermissionReplied   = "permission.replied"
+	TypeFileEdited          = "file.edited"
+	TypeDiagnostic          = "diagnostic"
+	TypeSessionError        = "session.error"
+	TypeSessionIdle         = "session.idle"
+	TypeSummary             = "summary"
+	TypeError               = "error"
+)
+
+// Event is a single NDJSON line. Directive holds the ID of the directive being
+// processed when the event occurred, if any.
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Directive string    `json:"directive,omitempty"`
+	Data      any       `json:"data,omitempty"`
+}
+
+// Emitter writes events as JSON lines. A nil *Emitter discards everything so
+// callers can emit unconditionally.
+type Emitter struct {
+	mu        sync.Mutex
+	enc       *json.Encoder
+	now       func() time.Time
+	directive string
+}
+
+// NewEmitter creates an Emitter writing to w.
+func NewEmitter(w io.Writer) *Emitter {
+	return &Emitter{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// SetDirective tags subsequent events with the directive ID; an empty ID clears it.
+func (e *Emitter) SetDirective(id string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.directive = id
+}
+
+// Emit writes an event of the given type carrying data.
+func (e *Emitter) Emit(typ string, data any) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_ = e.enc.Encode(Event{Type: typ, Time: e.now(), Directive: e.directive, Data: data})
+}
+
+// Directive describes a directive in run and lifecycle events.
+type Directive struct {
+	ID        string `json:"id"`
+	Function  string `json:"function"`
+	File      string `json:"file"`
+	StartLine uint   `json:"startLine"`
+	EndLine   uint   `json:"endLine"`
+	Prompt    string `json:"prompt,omitempty"`
+}
+
+// RunStarted is the payload of TypeRunStarted.
+type RunStarted struct {
+	RunID      string      `json:"runID"`
+	Session    string      `json:"session"`
+	File       string      `json:"file"`
+	Directives []Directive `json:"directives"`
+}
+
+// Failure is a check that did not pass.
+type Failure struct {
+	Check  string `json:"check"`
+	Output string `json:"output"`
+}
+
+// Repair is the payload of TypeDirectiveRepair.
+type Repair struct {
+	Round    int       `json:"round"`
+	Max      int       `json:"max"`
+	Failures []Failure `json:"failures"`
+}
+
+// Result is the payload of TypeDirectiveFinished and an entry in Summary.
+type Result struct {
+	ID       string `json:"id"`
+	Function string `json:"function"`
+	Status   string `json:"status"`
+	Tests    string `json:"tests,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Report is the agent's answer to a read-only directive.
+	Report string `json:"report,omitempty"`
+}
+
+// TextDelta is the payload of TypeTextDelta. Kind is "text" or "reasoning".
+type TextDelta struct {
+	Kind  string `json:"kind"`
+	Part  string `json:"part"`
+	Delta string `json:"delta"`
+}
+
+// Tool is the payload of TypeTool.
+type Tool struct {
+	CallID string `json:"callID"`
+	Tool   string `json:"tool"`
+	Title  string `json:"title,omitempty"`
+	Status string `json:"status"`
+}
+
+// Permission is the payload of TypePermissionRequested and TypePermissionReplied.
+type Permission struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Response string `json:"response,omitempty"`
+}
+
+// FileEdited is the payload of TypeFileEdited.
+type FileEdited struct {
+	File string `json:"file"`
+}
+
+// Diagnostic is the payload of TypeDiagnostic: either a language server
+// noting that Path has diagnostics, or one of them with its position.
+type Diagnostic struct {
+	Path     string `json:"path"`
+	Server   string `json:"server,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// SessionError is the payload of TypeSessionError.
+type SessionError struct {
+	Name string `json:"name"`
+}
+
+// SessionIdle is the payload of TypeSessionIdle with running totals.
+type SessionIdle struct {
+	InputTokens     float64 `json:"inputTokens"`
+	OutputTokens    float64 `json:"outputTokens"`
+	ReasoningTokens float64 `json:"reasoningTokens"`
+	Cost            float64 `json:"cost"`
+}
+
+// Summary is the payload of TypeSummary.
+type Summary struct {
+	Results []Result `json:"results"`
+	Failed  int      `json:"failed"`
+}
+
+// Error is the payload of TypeError, the last event of a run that failed.
+type Error struct {
+	Message string `json:"message"`
+}
@@ -0,0 +1,53 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEmitter(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEmitter(&buf)
+	e.now = func() time.Time { return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	e.Emit(TypeSessionIdle, nil)
+	e.SetDirective("abcd1234")
+	e.Emit(TypeFileEdited, FileEdited{File: "main.go"})
+	e.SetDirective("")
+	e.Emit(TypeSummary, Summary{Results: []Result{{ID: "abcd1234", Function: "main", Status: "succeeded"}}})
+
+	expected := `{"type":"session.idle","time":"2025-01-01T00:00:00Z"}` + "\n" +
+		`{"type":"file.edited","time":"2025-01-01T00:00:00Z","directive":"abcd1234","data":{"file":"main.go"}}` + "\n" +
+		`{"type":"summary","time":"2025-01-01T00:00:00Z","data":{"results":[{"id":"abcd1234","function":"main","status":"succeeded"}],"failed":0}}` + "\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestNilEmitter(t *testing.T) {
+	var e *Emitter
+	e.SetDirective("abcd1234")
+	e.Emit(TypeSessionIdle, nil)
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Format
+		wantErr bool
+	}{
+		{in: "text", want: FormatText},
+		{in: "ndjson", want: FormatNDJSON},
+		{in: "json", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFormat(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("ParseFormat(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
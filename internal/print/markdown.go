@@ -0,0 +1,56 @@
+package print
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	boldPattern       = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	inlineCodePattern = regexp.MustCompile("`([^`]+)`")
+	headingPattern    = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	listPattern       = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+)
+
+// Markdown prints text for a terminal: headings are bold, code blocks are
+// dimmed and indented, and list markers become bullets.
+func Markdown(w io.Writer, text string) {
+	inCode := false
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			fmt.Fprintln(w, colorize(GrayColor, "    "+line))
+			continue
+		}
+
+		switch {
+		case headingPattern.MatchString(line):
+			m := headingPattern.FindStringSubmatch(line)
+			fmt.Fprintln(w, bold(colorize(InfoColor, m[2])))
+		case listPattern.MatchString(line):
+			m := listPattern.FindStringSubmatch(line)
+			fmt.Fprintln(w, m[1]+"  "+Bullet+" "+inline(m[2]))
+		case strings.HasPrefix(line, ">"):
+			fmt.Fprintln(w, colorize(GrayColor, "│ "+strings.TrimSpace(strings.TrimPrefix(line, ">"))))
+		default:
+			fmt.Fprintln(w, inline(line))
+		}
+	}
+}
+
+// inline renders bold text and code spans within a line.
+func inline(line string) string {
+	line = inlineCodePattern.ReplaceAllStringFunc(line, func(s string) string {
+		return colorize(InfoColor, strings.Trim(s, "`"))
+	})
+	return boldPattern.ReplaceAllString(line, bold("$1"))
+}
+
+func bold(text string) string {
+	return "\x1b[1m" + text + ResetColor
+}
@@ -266,3 +266,17 @@ func TestSuccessf(t *testing.T) {
 		t.Errorf("Expected %q, got %q", expected, output)
 	}
 }
+
+func TestMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	Markdown(&buf, "## Findings\n\n- **nil** map in `load`\n```go\nx := 1\n```\n> quoted\n")
+
+	expected := "\x1b[1m\x1b[36mFindings\x1b[0m\x1b[0m\n" +
+		"\n" +
+		"  • \x1b[1mnil\x1b[0m map in \x1b[36mload\x1b[0m\n" +
+		"\x1b[90m    x := 1\x1b[0m\n" +
+		"\x1b[90m│ quoted\x1b[0m\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
@@ -0,0 +1,192 @@
+package prompt
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// Directive is the data available to the system and directive templates.
+type Directive struct {
+	// ID is the directive's stable identifier.
+	ID string
+	// Verb is what the directive asks for, such as "test" for "@ai:test";
+	// empty for a plain edit.
+	Verb string
+	// Function is the qualified name of the function containing the
+	// directive, such as "(*Service).GetUser" or "Handler.func1".
+	Function string
+	// File is the path of the source file.
+	File string
+	// StartLine and EndLine bound the function, 1-based and inclusive.
+	StartLine uint
+	EndLine   uint
+	// TestFile is the sibling _test.go file of a Go source file.
+	TestFile string
+	// ReadOnly reports that a directive which normally edits code runs in
+	// read-only mode, so the agent describes its change instead.
+	ReadOnly bool
+	// Language is the fenced-code language of File, such as "go".
+	Language string
+	// Instruction is the directive text with the @ai marker removed.
+	Instruction string
+	// Source is the complete source of the function, including the directive.
+	Source string
+	// Diagnostics are the compiler and vet messages inside the target before
+	// the agent runs; collected for fix directives.
+	Diagnostics []Diagnostic
+	// Enclosing is the function around a target that is a function literal,
+	// shown to the agent as read-only context. Nil otherwise.
+	Enclosing *Enclosing
+	// Symbols are declarations the target refers to, given as read-only
+	// context so the agent need not look them up.
+	Symbols []Symbol
+	// Usages are functions that call the target and tests that reference it,
+	// given as read-only context showing how the target is used.
+	Usages []Symbol
+	// Attributes are the arguments of the directive's marker, such as
+	// failed and run for a directive retried after "@ai-failed(...)".
+	Attributes map[string]string
+
+	// Package is the package clause of File and Imports its import paths, so
+	// the agent knows what is already available without reading the file.
+	Package string
+	Imports []string
+}
+
+// Diagnostic is a compiler or vet message about a line of File.
+type Diagnostic struct {
+	Check   string
+	Line    int
+	Column  int
+	Message string
+}
+
+// Enclosing is the read-only context around a function literal.
+type Enclosing struct {
+	Function  string
+	StartLine uint
+	EndLine   uint
+	Source    string
+}
+
+// Symbol is a declaration shown as read-only context.
+type Symbol struct {
+	Kind      string
+	Name      string
+	File      string
+	StartLine uint
+	EndLine   uint
+	Source    string
+}
+
+// Repair is the data available to the repair template.
+type Repair struct {
+	Directive
+	// Round is the repair attempt being requested, starting at 1, out of MaxRounds.
+	Round     int
+	MaxRounds int
+	// Failures is the combined output of the checks that failed.
+	Failures string
+}
+
+// Templates holds the parsed text/template sources for the messages sent to
+// the agent. The built-in templates live in prompts/ and can be replaced per
+// project through the [prompts] section of .chisel.toml.
+type Templates struct {
+	system    *template.Template
+	readOnly  *template.Template
+	directive *template.Template
+	repair    *template.Template
+	verbs     map[string]*template.Template
+}
+
+// Sources are the texts of the prompt templates.
+type Sources struct {
+	// System is the system prompt for plain edits.
+	System string
+	// ReadOnly is the system prompt for editing directives run read-only.
+	ReadOnly string
+	// Directive describes the directive and its function.
+	Directive string
+	// Repair reports failed checks.
+	Repair string
+	// Verbs holds the system prompt for each directive verb.
+	Verbs map[string]string
+}
+
+// Parse parses src. Each template is executed once against empty data so
+// unknown fields are reported now rather than when the first directive is
+// sent.
+func Parse(src Sources) (*Templates, error) {
+	t := Templates{verbs: map[string]*template.Template{}}
+	var err error
+	if t.system, err = parse("system", src.System, Directive{}); err != nil {
+		return nil, err
+	}
+	if t.readOnly, err = parse("read-only", src.ReadOnly, Directive{}); err != nil {
+		return nil, err
+	}
+	if t.directive, err = parse("directive", src.Directive, Directive{}); err != nil {
+		return nil, err
+	}
+	if t.repair, err = parse("repair", src.Repair, Repair{}); err != nil {
+		return nil, err
+	}
+	for verb, text := range src.Verbs {
+		if t.verbs[verb], err = parse(verb, text, Directive{}); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// HasVerb reports whether there is a system prompt for verb.
+func (t *Templates) HasVerb(verb string) bool {
+	_, ok := t.verbs[verb]
+	return verb == "" || ok
+}
+
+func parse(name, text string, sample any) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s prompt: %w", name, err)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, sample); err != nil {
+		return nil, fmt.Errorf("checking %s prompt: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// System renders the system prompt for d, chosen by its verb.
+func (t *Templates) System(d Directive) (string, error) {
+	if d.ReadOnly {
+		return execute(t.readOnly, d)
+	}
+	if d.Verb == "" {
+		return execute(t.system, d)
+	}
+	tmpl, ok := t.verbs[d.Verb]
+	if !ok {
+		return "", fmt.Errorf("no prompt for directive verb %q", d.Verb)
+	}
+	return execute(tmpl, d)
+}
+
+// Directive renders the prompt describing d.
+func (t *Templates) Directive(d Directive) (string, error) {
+	return execute(t.directive, d)
+}
+
+// Repair renders the follow-up prompt reporting failed checks.
+func (t *Templates) Repair(r Repair) (string, error) {
+	return execute(t.repair, r)
+}
+
+func execute(tmpl *template.Template, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("rendering %s prompt: %w", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,194 @@
+package prompt
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func builtin(t *testing.T) *Templates {
+	t.Helper()
+	read := func(name string) string {
+		b, err := os.ReadFile("../../prompts/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	tmpl, err := Parse(Sources{
+		System:    read("system.md"),
+		ReadOnly:  read("read-only.md"),
+		Directive: read("directive-context.md"),
+		Repair:    read("repair.md"),
+		Verbs: map[string]string{
+			"test":    read("verbs/test.md"),
+			"doc":     read("verbs/doc.md"),
+			"explain": read("verbs/explain.md"),
+			"review":  read("verbs/review.md"),
+			"fix":     read("verbs/fix.md"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("parsing built-in prompts: %v", err)
+	}
+	return tmpl
+}
+
+func TestDirective(t *testing.T) {
+	data := Directive{
+		Function:    "doSomething",
+		File:        "main.go",
+		StartLine:   3,
+		EndLine:     6,
+		Language:    "go",
+		Instruction: "add error handling",
+		Source:      "func doSomething() {}",
+	}
+
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		enclosing  *Enclosing
+		symbols    []Symbol
+		usages     []Symbol
+		diags      []Diagnostic
+		expected   string
+	}{
+		{
+			name: "plain directive",
+			expected: "Target: `doSomething` in `main.go` (lines 3-6)\n\n" +
+				"<directive>\nadd error handling\n</directive>\n\n" +
+				"```go\nfunc doSomething() {}\n```\n",
+		},
+		{
+			name:       "retried directive",
+			attributes: map[string]string{"failed": "validation", "run": "1a2b3c4d"},
+			expected: "Target: `doSomething` in `main.go` (lines 3-6)\n\n" +
+				"A previous attempt at this directive failed (validation).\n\n" +
+				"<directive>\nadd error handling\n</directive>\n\n" +
+				"```go\nfunc doSomething() {}\n```\n",
+		},
+		{
+			name:      "function literal",
+			enclosing: &Enclosing{Function: "run", StartLine: 1, EndLine: 9, Source: "func run() {}"},
+			expected: "Target: `doSomething` in `main.go` (lines 3-6)\n\n" +
+				"<directive>\nadd error handling\n</directive>\n\n" +
+				"```go\nfunc doSomething() {}\n```\n\n" +
+				"The target is a function literal inside `run` (lines 1-9). Its source is read-only context for captured variables and surrounding logic; edit only the literal above.\n\n" +
+				"<readonly-context>\n```go\nfunc run() {}\n```\n</readonly-context>\n",
+		},
+		{
+			name:    "symbols",
+			symbols: []Symbol{{Name: "User", File: "user.go", StartLine: 3, EndLine: 5, Source: "type User struct{}"}},
+			expected: "Target: `doSomething` in `main.go` (lines 3-6)\n\n" +
+				"<directive>\nadd error handling\n</directive>\n\n" +
+				"```go\nfunc doSomething() {}\n```\n\n" +
+				"Declarations the target refers to. They are read-only context; use them as shown instead of looking them up.\n\n" +
+				"<readonly-context>\n// user.go:3-5\n```go\ntype User struct{}\n```\n</readonly-context>\n",
+		},
+		{
+			name:  "diagnostics",
+			diags: []Diagnostic{{Check: "build", Line: 4, Column: 2, Message: "undefined: x"}, {Check: "vet", Line: 5, Message: "unreachable code"}},
+			expected: "Target: `doSomething` in `main.go` (lines 3-6)\n\n" +
+				"<directive>\nadd error handling\n</directive>\n\n" +
+				"```go\nfunc doSomething() {}\n```\n\n" +
+				"Diagnostics reported inside the target before your edit:\n\n" +
+				"<diagnostics>\nmain.go:4:2: undefined: x (build)\nmain.go:5: unreachable code (vet)\n</diagnostics>\n",
+		},
+		{
+			name:   "usages",
+			usages: []Symbol{{Kind: "test", Name: "TestDo", File: "main_test.go", StartLine: 8, EndLine: 10, Source: "func TestDo(t *testing.T) {}"}},
+			expected: "Target: `doSomething` in `main.go` (lines 3-6)\n\n" +
+				"<directive>\nadd error handling\n</directive>\n\n" +
+				"```go\nfunc doSomething() {}\n```\n\n" +
+				"Code that calls the target and tests that exercise it. They are read-only context showing how the target is used; keep them working, or say what must change if the directive breaks them.\n\n" +
+				"<readonly-context>\n// main_test.go:8-10 (test)\n```go\nfunc TestDo(t *testing.T) {}\n```\n</readonly-context>\n",
+		},
+	}
+	tmpl := builtin(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data.Attributes = tt.attributes
+			data.Enclosing = tt.enclosing
+			data.Symbols = tt.symbols
+			data.Usages = tt.usages
+			data.Diagnostics = tt.diags
+			got, err := tmpl.Directive(data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRepair(t *testing.T) {
+	got, err := builtin(t).Repair(Repair{
+		Directive: Directive{Function: "doSomething", File: "main.go"},
+		Round:     1,
+		MaxRounds: 2,
+		Failures:  "build: undefined: x",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(got, "Your edit to `doSomething` in `main.go` failed validation (attempt 1 of 2).\n\n<failures>\nbuild: undefined: x\n</failures>") {
+		t.Errorf("unexpected repair prompt %q", got)
+	}
+}
+
+func TestSystem(t *testing.T) {
+	tmpl := builtin(t)
+	data := Directive{Function: "doSomething", File: "svc.go", TestFile: "svc_test.go"}
+
+	plain, err := tmpl.System(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data.Verb = "test"
+	test, err := tmpl.System(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if test == plain || !strings.Contains(test, "svc_test.go") {
+		t.Errorf("expected the test prompt to name the test file, got %q", test)
+	}
+
+	data.Verb, data.ReadOnly = "fix", true
+	readOnly, err := tmpl.System(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(readOnly, "read-only mode") || !strings.Contains(readOnly, "`// @ai:fix`") {
+		t.Errorf("expected the read-only prompt, got %q", readOnly)
+	}
+
+	data.Verb, data.ReadOnly = "refactor", false
+	if _, err := tmpl.System(data); err == nil {
+		t.Error("expected an error for an unknown verb")
+	}
+	if tmpl.HasVerb("refactor") || !tmpl.HasVerb("") || !tmpl.HasVerb("review") {
+		t.Error("HasVerb reported the wrong verbs")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  Sources
+	}{
+		{name: "syntax", src: Sources{Directive: "{{.Function"}},
+		{name: "unknown field", src: Sources{Directive: "{{.Funcion}}"}},
+		{name: "repair field in directive", src: Sources{Directive: "{{.Round}}"}},
+		{name: "unknown field in verb", src: Sources{Verbs: map[string]string{"test": "{{.Tests}}"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.src); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
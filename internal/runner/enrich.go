@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/enrich"
+	"github.com/thomasgormley/chisel/internal/print"
+	"github.com/thomasgormley/chisel/internal/prompt"
+	"github.com/thomasgormley/chisel/internal/validate"
+)
+
+// defaultCallersBudget is used by "@ai(callers=true)" when callers are
+// otherwise turned off.
+const defaultCallersBudget = 2048
+
+// enrich adds read-only context about the Go target of d to data. Context is
+// optional, so failures are reported and the prompt goes out without it.
+func (r *Runner) enrich(ctx context.Context, sourceFile string, d directive.AIDirective, data *prompt.Directive) {
+	data.Symbols = promptSymbols(r.declarations(ctx, sourceFile, d))
+
+	usages, err := enrich.Usages(sourceFile, d, r.callersBudget(d))
+	if err != nil {
+		print.Warningf(r.out(), print.Wrap("Could not find callers of %s: %s"), d.Function, err)
+	}
+	data.Usages = promptSymbols(usages)
+}
+
+// declarations returns the declarations the target of d refers to.
+func (r *Runner) declarations(ctx context.Context, sourceFile string, d directive.AIDirective) []enrich.Symbol {
+	if r.opts.ContextBudget <= 0 {
+		return nil
+	}
+	code, err := os.ReadFile(sourceFile)
+	if err != nil {
+		print.Warningf(r.out(), print.Wrap("Could not read %s for context: %s"), sourceFile, err)
+		return nil
+	}
+	symbols, err := enrich.SameFile(sourceFile, code, d, r.opts.ContextBudget)
+	if err != nil {
+		print.Warningf(r.out(), print.Wrap("Could not collect context for %s: %s"), d.Function, err)
+		return nil
+	}
+	if r.opts.ContextTypes {
+		budget := r.opts.ContextBudget
+		for _, s := range symbols {
+			budget -= len(s.Source)
+		}
+		external, err := enrich.Types(ctx, sourceFile, d, budget)
+		if err != nil {
+			print.Warningf(r.out(), print.Wrap("Could not load type information for %s: %s"), d.Function, err)
+		}
+		symbols = append(symbols, external...)
+	}
+	return symbols
+}
+
+// callersBudget returns the budget for callers and tests of d. The "callers"
+// attribute overrides it per directive: "@ai(callers=false)" leaves them out,
+// "@ai(callers=true)" includes them, and "@ai(callers=8192)" sets the budget.
+func (r *Runner) callersBudget(d directive.AIDirective) int {
+	value, ok := d.Attributes()["callers"]
+	if !ok {
+		return r.opts.ContextCallers
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		return n
+	}
+	if on, err := strconv.ParseBool(value); err != nil || !on {
+		return 0
+	}
+	if r.opts.ContextCallers > 0 {
+		return r.opts.ContextCallers
+	}
+	return defaultCallersBudget
+}
+
+// asksForFix reports whether d asks for a fix: an "@ai:fix" directive, or a
+// plain one whose instruction starts with "fix", as in "@ai fix the build
+// error". Only those get build diagnostics, since collecting them builds and
+// vets the package.
+func asksForFix(d directive.AIDirective) bool {
+	switch d.Verb {
+	case directive.VerbFix:
+		return true
+	case directive.VerbEdit:
+		instruction, err := d.Prompt()
+		if err != nil {
+			return false
+		}
+		word, _, _ := strings.Cut(strings.ToLower(instruction), " ")
+		return strings.TrimRight(word, ":,.") == "fix"
+	}
+	return false
+}
+
+// diagnose adds the build and vet diagnostics inside the target of d to data,
+// so the agent need not rediscover them.
+func (r *Runner) diagnose(ctx context.Context, sourceFile string, d directive.AIDirective, data *prompt.Directive) {
+	diagnostics, err := validate.Diagnostics(ctx, r.opts.Dir, sourceFile, d.StartLine, d.EndLine)
+	if err != nil {
+		print.Warningf(r.out(), print.Wrap("Could not collect diagnostics for %s: %s"), d.Function, err)
+		return
+	}
+	for _, diag := range diagnostics {
+		data.Diagnostics = append(data.Diagnostics, prompt.Diagnostic{
+			Check:   diag.Check,
+			Line:    diag.Line,
+			Column:  diag.Column,
+			Message: diag.Message,
+		})
+	}
+}
+
+func promptSymbols(symbols []enrich.Symbol) []prompt.Symbol {
+	out := make([]prompt.Symbol, len(symbols))
+	for i, s := range symbols {
+		out[i] = prompt.Symbol{
+			Kind:      s.Kind,
+			Name:      s.Name,
+			File:      s.File,
+			StartLine: s.StartLine,
+			EndLine:   s.EndLine,
+			Source:    s.Source,
+		}
+	}
+	return out
+}
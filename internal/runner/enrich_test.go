@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+)
+
+func TestCallersBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		comment    string
+		configured int
+		want       int
+	}{
+		{name: "configured", comment: "// @ai tidy", configured: 1024, want: 1024},
+		{name: "turned off", comment: "// @ai(callers=false) tidy", configured: 1024, want: 0},
+		{name: "turned on", comment: "// @ai(callers=true) tidy", want: defaultCallersBudget},
+		{name: "turned on with a budget configured", comment: "// @ai(callers=true) tidy", configured: 1024, want: 1024},
+		{name: "budget", comment: "// @ai(callers=8192) tidy", configured: 1024, want: 8192},
+		{name: "invalid", comment: "// @ai(callers=maybe) tidy", configured: 1024, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Runner{opts: Options{ContextCallers: tt.configured}}
+			if got := r.callersBudget(directive.AIDirective{Comment: tt.comment}); got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAsksForFix(t *testing.T) {
+	tests := []struct {
+		verb    directive.Verb
+		comment string
+		want    bool
+	}{
+		{verb: directive.VerbFix, comment: "// @ai:fix handle nil users", want: true},
+		{verb: directive.VerbEdit, comment: "// @ai fix the build error", want: true},
+		{verb: directive.VerbEdit, comment: "// @ai Fix: the vet warning", want: true},
+		{verb: directive.VerbEdit, comment: "// @ai add a fixture loader"},
+		{verb: directive.VerbReview, comment: "// @ai:review fix the naming"},
+		{verb: directive.VerbExplain, comment: "// @ai:explain"},
+	}
+	for _, tt := range tests {
+		d := directive.AIDirective{Verb: tt.verb, Comment: tt.comment}
+		if got := asksForFix(d); got != tt.want {
+			t.Errorf("asksForFix(%q) = %v, want %v", tt.comment, got, tt.want)
+		}
+	}
+}
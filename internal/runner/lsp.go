@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/prompt"
+)
+
+// LSPCheckName is the check name of language server errors reported after an
+// edit.
+const LSPCheckName = "lsp"
+
+// LSPError is an error a language server reported in a file the agent
+// edited.
+type LSPError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+// lspErrors holds the latest language server errors per absolute path.
+type lspErrors struct {
+	mu     sync.Mutex
+	byPath map[string][]LSPError
+}
+
+// SetLSPErrors records the errors a language server reported in path after an
+// edit, replacing any earlier ones. When validation is enabled, errors inside
+// the directive's function are sent back to the agent to fix, like a failed
+// check.
+func (r *Runner) SetLSPErrors(path string, errs []LSPError) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	r.lsp.mu.Lock()
+	defer r.lsp.mu.Unlock()
+	if r.lsp.byPath == nil {
+		r.lsp.byPath = map[string][]LSPError{}
+	}
+	r.lsp.byPath[abs] = errs
+}
+
+// clearLSPErrors forgets the errors recorded for path, so a directive is only
+// held to those its own edits leave behind.
+func (r *Runner) clearLSPErrors(path string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	r.lsp.mu.Lock()
+	defer r.lsp.mu.Unlock()
+	delete(r.lsp.byPath, abs)
+}
+
+// lspFailure returns the recorded language server errors inside the target
+// of data as a failure report, and false if there are none. The target is
+// found again by name, since the edit may have moved or resized it.
+func (r *Runner) lspFailure(sourceFile string, data prompt.Directive) (string, bool) {
+	abs, err := filepath.Abs(sourceFile)
+	if err != nil {
+		abs = sourceFile
+	}
+	r.lsp.mu.Lock()
+	errs := r.lsp.byPath[abs]
+	r.lsp.mu.Unlock()
+	if len(errs) == 0 {
+		return "", false
+	}
+
+	start, end := data.StartLine, data.EndLine
+	if code, err := os.ReadFile(sourceFile); err == nil {
+		if d, err := r.parser.Synthesize(code, directive.Target{Function: data.Function}, directive.VerbEdit, "locate"); err == nil {
+			start, end = d.StartLine, d.EndLine
+		}
+	}
+
+	var lines []string
+	for _, e := range errs {
+		if e.Line >= int(start) && e.Line <= int(end) {
+			lines = append(lines, fmt.Sprintf("%s:%d:%d: %s", sourceFile, e.Line, e.Column, e.Message))
+		}
+	}
+	if len(lines) == 0 {
+		return "", false
+	}
+	return strings.Join(lines, "\n"), true
+}
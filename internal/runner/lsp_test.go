@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/thomasgormley/chisel/internal/prompt"
+)
+
+func TestLSPFailure(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "main.go")
+	src := "package main\n\nfunc helper() {}\n\nfunc run() {\n\tx := 1\n\n\t_ = y\n}\n"
+	if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r := New(nil, Options{})
+	// The directive saw run at lines 5-7 before the edit grew it to 5-9.
+	data := prompt.Directive{Function: "run", StartLine: 5, EndLine: 7}
+
+	if _, ok := r.lspFailure(file, data); ok {
+		t.Error("expected no failure before any errors are reported")
+	}
+
+	r.SetLSPErrors(file, []LSPError{
+		{Line: 3, Column: 6, Message: "outside the target"},
+		{Line: 6, Column: 2, Message: "declared and not used: x"},
+		{Line: 8, Column: 6, Message: "undefined: y"},
+	})
+	got, ok := r.lspFailure(file, data)
+	want := file + ":6:2: declared and not used: x\n" + file + ":8:6: undefined: y"
+	if !ok || got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	if failures, err := r.validate(context.Background(), "", file, importsEdit{}, data, nil); err != nil || len(failures) > 0 {
+		t.Errorf("expected no failures with validation disabled, got %v, %v", failures, err)
+	}
+
+	r.clearLSPErrors(file)
+	if _, ok := r.lspFailure(file, data); ok {
+		t.Error("expected no failure after clearing")
+	}
+}
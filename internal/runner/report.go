@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sst/opencode-sdk-go"
+	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/print"
+)
+
+// readOnlyDenied lists the tools and permission types that can change the
+// workspace. They are disabled for read-only directives.
+var readOnlyDenied = []string{"edit", "write", "patch", "bash"}
+
+func readOnlyTools() map[string]bool {
+	tools := map[string]bool{}
+	for _, tool := range readOnlyDenied {
+		tools[tool] = false
+	}
+	return tools
+}
+
+// Denies reports whether a permission request of type typ must be rejected
+// because the directive being processed is read-only.
+func (r *Runner) Denies(typ string) bool {
+	if !r.readOnly.Load() {
+		return false
+	}
+	for _, denied := range readOnlyDenied {
+		if typ == denied {
+			return true
+		}
+	}
+	return false
+}
+
+// finalText joins the text parts of the agent's last message.
+func finalText(parts []opencode.Part) string {
+	var texts []string
+	for _, part := range parts {
+		if part.Type == opencode.PartTypeText && !part.Synthetic && strings.TrimSpace(part.Text) != "" {
+			texts = append(texts, strings.TrimSpace(part.Text))
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// report prints the answer to a read-only directive as Markdown, or appends it
+// to the configured report file.
+func (r *Runner) report(sourceFile string, d directive.AIDirective, answer string) error {
+	if answer == "" {
+		print.Warning(r.out(), "The agent gave no answer for", d.Function)
+		return nil
+	}
+	if r.opts.Report == "" {
+		print.Info(r.out())
+		print.Markdown(r.out(), answer)
+		return nil
+	}
+
+	f, err := os.OpenFile(r.opts.Report, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening report: %w", err)
+	}
+	_, err = fmt.Fprint(f, reportSection(sourceFile, d, answer))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	print.Successf(r.out(), print.Wrap("📝 Answer for %s written to %s"), d.Function, r.opts.Report)
+	return nil
+}
+
+// reportSection formats answer as a Markdown section headed by the directive.
+func reportSection(sourceFile string, d directive.AIDirective, answer string) string {
+	marker := "@ai"
+	if d.Verb != directive.VerbEdit {
+		marker += ":" + string(d.Verb)
+	}
+	instruction, _ := d.Prompt()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "## `%s` in `%s` (lines %d-%d)\n\n", d.Function, sourceFile, d.StartLine, d.EndLine)
+	if instruction != "" {
+		fmt.Fprintf(&b, "> %s %s\n\n", marker, strings.ReplaceAll(instruction, "\n", " "))
+	} else {
+		fmt.Fprintf(&b, "> %s\n\n", marker)
+	}
+	fmt.Fprintf(&b, "%s\n\n", answer)
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/sst/opencode-sdk-go"
+	"github.com/thomasgormley/chisel/internal/directive"
+)
+
+func TestFinalText(t *testing.T) {
+	parts := []opencode.Part{
+		{Type: opencode.PartTypeStepStart},
+		{Type: opencode.PartTypeText, Text: "Looks fine.\n"},
+		{Type: opencode.PartTypeTool, Tool: "read"},
+		{Type: opencode.PartTypeText, Text: "context", Synthetic: true},
+		{Type: opencode.PartTypeText, Text: "- one nit"},
+	}
+	if got, want := finalText(parts), "Looks fine.\n\n- one nit"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestReportSection(t *testing.T) {
+	d := directive.AIDirective{
+		Verb:      directive.VerbReview,
+		Comment:   "// @ai:review check the error paths",
+		Function:  "load",
+		StartLine: 10,
+		EndLine:   20,
+	}
+	got := reportSection("config.go", d, "- err is ignored on line 12")
+	want := "## `load` in `config.go` (lines 10-20)\n\n" +
+		"> @ai:review check the error paths\n\n" +
+		"- err is ignored on line 12\n\n"
+	if got != want {
+		t.Errorf("\n  expected: %q\n  got:      %q", want, got)
+	}
+}
+
+func TestDenies(t *testing.T) {
+	var r Runner
+	if r.Denies("edit") {
+		t.Error("expected edits allowed outside read-only directives")
+	}
+	r.readOnly.Store(true)
+	for typ, want := range map[string]bool{"edit": true, "bash": true, "webfetch": false} {
+		if got := r.Denies(typ); got != want {
+			t.Errorf("Denies(%q) = %v, want %v", typ, got, want)
+		}
+	}
+}
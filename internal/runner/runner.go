@@ -0,0 +1,775 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sst/opencode-sdk-go"
+	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/importfix"
+	"github.com/thomasgormley/chisel/internal/output"
+	"github.com/thomasgormley/chisel/internal/print"
+	"github.com/thomasgormley/chisel/internal/prompt"
+	"github.com/thomasgormley/chisel/internal/validate"
+)
+
+// Options configures how directives are sent to the agent and checked afterwards.
+type Options struct {
+	Dir      string
+	Model    string
+	Provider string
+
+	// Prompts render the system message, the directive description and the
+	// report of validation failures.
+	Prompts *prompt.Templates
+
+	// Checks run after each directive on Go files. Empty disables validation.
+	Checks []validate.Check
+	// RepairRounds bounds how many follow-up prompts are sent to fix failed checks.
+	RepairRounds int
+
+	// FixImports resolves import TODO markers and missing or unused imports
+	// in the edited file before validation.
+	FixImports bool
+
+	// Leftover decides what happens to directive comments the agent did not remove.
+	Leftover LeftoverMode
+
+	// RunID identifies this run in failure markers.
+	RunID string
+	// MarkFailures rewrites failed directives as "@ai-failed(<reason>, run=<id>)".
+	MarkFailures bool
+	// RetryFailed processes directives previously marked as failed.
+	RetryFailed bool
+
+	// Test runs the package's tests after each directive alongside Checks.
+	Test bool
+	// TestRelated narrows Test to tests that reference the target function.
+	TestRelated bool
+
+	// ContextBudget bounds, in bytes, the declarations from the same file that
+	// are added to the prompt as context. Zero leaves them out.
+	ContextBudget int
+	// ContextTypes also loads the package with type information and adds
+	// declarations from other files and packages, within the same budget.
+	ContextTypes bool
+	// ContextCallers bounds, in bytes, the callers and tests of the target
+	// added to the prompt. Zero leaves them out unless a directive asks.
+	ContextCallers int
+
+	// ReadOnly asks for the agent's answer to every directive instead of an
+	// edit, and leaves the source file untouched.
+	ReadOnly bool
+	// Report is a Markdown file that answers to read-only directives are
+	// appended to. Empty prints them instead.
+	Report string
+
+	// Out receives human-readable progress. Defaults to os.Stdout.
+	Out io.Writer
+	// Emitter receives machine-readable lifecycle events. Nil disables them.
+	Emitter *output.Emitter
+}
+
+// LeftoverMode controls how a directive comment left behind by the agent is handled.
+type LeftoverMode string
+
+const (
+	// LeftoverStrip deletes the comment block.
+	LeftoverStrip LeftoverMode = "strip"
+	// LeftoverMark rewrites the marker as "@ai-done" so it is not processed again.
+	LeftoverMark LeftoverMode = "mark"
+)
+
+// Status describes the outcome of a single directive.
+type Status string
+
+const (
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusSkipped   Status = "skipped"
+)
+
+// TestOutcome describes the result of running tests for a directive.
+type TestOutcome string
+
+const (
+	TestsNotRun TestOutcome = ""
+	TestsPassed TestOutcome = "passed"
+	TestsFailed TestOutcome = "failed"
+)
+
+// Reasons recorded for failed directives.
+const (
+	ReasonPrompt     = "prompt"
+	ReasonSession    = "session"
+	ReasonValidation = "validation"
+	ReasonTests      = "tests"
+	ReasonScope      = "scope"
+	ReasonReport     = "report"
+)
+
+// Result records what happened to a directive.
+type Result struct {
+	Directive directive.AIDirective
+	Status    Status
+	Tests     TestOutcome
+	// Reason is a short code describing why the directive failed.
+	Reason string
+	Err    error
+	// Report is the agent's answer to a read-only directive.
+	Report string
+}
+
+// SessionError reports an error the agent hit while answering a prompt.
+type SessionError struct {
+	Name string
+}
+
+func (e *SessionError) Error() string {
+	return fmt.Sprintf("session error: %s", e.Name)
+}
+
+// Runner sends directives to an opencode session one at a time.
+type Runner struct {
+	client *opencode.Client
+	parser *directive.Parser
+	opts   Options
+
+	// readOnly is set while a read-only directive is being processed. It
+	// covers every session the runner serves, so callers run one directive
+	// at a time.
+	readOnly atomic.Bool
+	// lsp holds the language server errors reported after the agent's edits.
+	lsp lspErrors
+}
+
+// New creates a Runner using client and opts.
+func New(client *opencode.Client, opts Options) *Runner {
+	return &Runner{
+		client: client,
+		parser: directive.NewParser(directive.WithFailed(opts.RetryFailed)),
+		opts:   opts,
+	}
+}
+
+// Run processes directives from sourceFile in order within sessionID. It stops
+// at the first error talking to the server; directives that fail validation are
+// recorded as failed and processing continues.
+func (r *Runner) Run(ctx context.Context, sessionID, sourceFile string, directives []directive.AIDirective) ([]Result, error) {
+	em := r.opts.Emitter
+	defer em.SetDirective("")
+
+	started := output.RunStarted{RunID: r.opts.RunID, Session: sessionID, File: sourceFile}
+	for _, d := range directives {
+		started.Directives = append(started.Directives, directiveEvent(sourceFile, d))
+	}
+	em.Emit(output.TypeRunStarted, started)
+
+	var results []Result
+	for i, d := range directives {
+		if i > 0 {
+			// Earlier edits shift offsets, so pick up the directive's current position.
+			d = r.refresh(sourceFile, d)
+		}
+		em.SetDirective(d.ID())
+		em.Emit(output.TypeDirectiveStarted, directiveEvent(sourceFile, d))
+
+		print.Info(r.out(), "Processing directive in function:", d.Function)
+		print.Info(r.out(), "->", r.opts.Provider, "/", r.opts.Model)
+
+		if os.Getenv("SKIP_PROCESS") == "1" {
+			print.Warning(r.out(), "Skipping processing")
+			result := Result{Directive: d, Status: StatusSkipped}
+			em.Emit(output.TypeDirectiveFinished, resultEvent(result))
+			results = append(results, result)
+			continue
+		}
+
+		result, err := r.process(ctx, sessionID, sourceFile, d)
+		switch {
+		case r.opts.ReadOnly:
+			// The source file is left exactly as it was.
+		case result.Status == StatusSucceeded:
+			r.removeLeftover(sourceFile, d)
+		case result.Status == StatusFailed && ctx.Err() == nil:
+			r.markFailed(sourceFile, d, result.Reason)
+		}
+		em.Emit(output.TypeDirectiveFinished, resultEvent(result))
+		results = append(results, result)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// out returns the writer for human-readable progress.
+func (r *Runner) out() io.Writer {
+	if r.opts.Out == nil {
+		return os.Stdout
+	}
+	return r.opts.Out
+}
+
+// refresh re-parses sourceFile and returns the current form of d, or d
+// unchanged if it can no longer be found.
+func (r *Runner) refresh(sourceFile string, d directive.AIDirective) directive.AIDirective {
+	_, current, err := r.parseFile(sourceFile)
+	if err != nil {
+		return d
+	}
+	if found, ok := directive.Locate(current, d); ok {
+		return found
+	}
+	return d
+}
+
+// removeLeftover checks whether the comment block for d survived the edit and,
+// if so, strips or marks it according to the configured LeftoverMode.
+func (r *Runner) removeLeftover(sourceFile string, d directive.AIDirective) {
+	if r.opts.Leftover == "" {
+		return
+	}
+
+	code, current, err := r.parseFile(sourceFile)
+	if err != nil {
+		print.Warningf(r.out(), print.Wrap("Could not re-parse %s: %s"), sourceFile, err)
+		return
+	}
+	leftover, ok := directive.Locate(current, d)
+	if !ok {
+		return
+	}
+
+	switch r.opts.Leftover {
+	case LeftoverMark:
+		code = directive.MarkDone(code, leftover)
+	default:
+		code = directive.Strip(code, leftover)
+	}
+	if err := writeFile(sourceFile, code); err != nil {
+		print.Warningf(r.out(), print.Wrap("Could not remove directive from %s: %s"), sourceFile, err)
+		return
+	}
+	print.Notef(r.out(), print.Wrap("🧹 Directive comment left in %s was %s"), d.Function, leftoverVerb(r.opts.Leftover))
+}
+
+// markFailed writes a failure marker over the directive for d when enabled.
+func (r *Runner) markFailed(sourceFile string, d directive.AIDirective, reason string) {
+	if !r.opts.MarkFailures {
+		return
+	}
+
+	code, current, err := r.parseFile(sourceFile)
+	if err != nil {
+		print.Warningf(r.out(), print.Wrap("Could not re-parse %s: %s"), sourceFile, err)
+		return
+	}
+	failed, ok := directive.Locate(current, d)
+	if !ok {
+		return
+	}
+
+	if err := writeFile(sourceFile, directive.MarkFailed(code, failed, reason, r.opts.RunID)); err != nil {
+		print.Warningf(r.out(), print.Wrap("Could not mark directive as failed in %s: %s"), sourceFile, err)
+		return
+	}
+	print.Warningf(r.out(), print.Wrap("📌 Marked directive in %s as failed (%s)"), d.Function, reason)
+}
+
+// parseFile reads sourceFile and returns its contents and directives.
+func (r *Runner) parseFile(sourceFile string) ([]byte, []directive.AIDirective, error) {
+	code, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	directives, err := r.parser.Parse(code)
+	if err != nil {
+		return nil, nil, err
+	}
+	return code, directives, nil
+}
+
+// Prompt renders the message sent to the agent for d, leaving out build
+// diagnostics, which take a build and vet to collect.
+func (r *Runner) Prompt(ctx context.Context, sourceFile string, d directive.AIDirective) (string, error) {
+	data, err := r.promptData(ctx, sourceFile, d)
+	if err != nil {
+		return "", err
+	}
+	return r.opts.Prompts.Directive(data)
+}
+
+// promptData describes d for the prompt templates.
+func (r *Runner) promptData(ctx context.Context, sourceFile string, d directive.AIDirective) (prompt.Directive, error) {
+	instruction, err := d.Prompt()
+	if err != nil {
+		return prompt.Directive{}, err
+	}
+	data := prompt.Directive{
+		ID:          d.ID(),
+		Verb:        string(d.Verb),
+		ReadOnly:    r.opts.ReadOnly && verbScopes[d.Verb].edits,
+		Function:    d.Function,
+		File:        sourceFile,
+		StartLine:   d.StartLine,
+		EndLine:     d.EndLine,
+		Language:    DetectLanguage(sourceFile),
+		Instruction: instruction,
+		Source:      d.Source,
+		Attributes:  d.Attributes(),
+	}
+	if e := d.Enclosing; e != nil {
+		data.Enclosing = &prompt.Enclosing{
+			Function:  e.Function,
+			StartLine: e.StartLine,
+			EndLine:   e.EndLine,
+			Source:    e.Source,
+		}
+	}
+	if data.Language == "go" {
+		data.TestFile = TestFile(sourceFile)
+		// The file header is extra context; a file that doesn't parse still gets a prompt.
+		if f, err := parser.ParseFile(token.NewFileSet(), sourceFile, nil, parser.ImportsOnly); err == nil {
+			data.Package = f.Name.Name
+			for _, imp := range f.Imports {
+				data.Imports = append(data.Imports, strings.Trim(imp.Path.Value, `"`))
+			}
+		}
+		r.enrich(ctx, sourceFile, d, &data)
+	}
+	return data, nil
+}
+
+// process sends a single directive and runs the validation loop over its edit.
+func (r *Runner) process(ctx context.Context, sessionID, sourceFile string, d directive.AIDirective) (Result, error) {
+	rule, ok := verbScopes[d.Verb]
+	if !ok || !r.opts.Prompts.HasVerb(string(d.Verb)) {
+		err := fmt.Errorf("unknown directive verb %q", d.Verb)
+		return Result{Directive: d, Status: StatusFailed, Reason: ReasonPrompt, Err: err}, nil
+	}
+	if r.opts.ReadOnly {
+		rule = scope{source: scopeReadOnly}
+	}
+	r.readOnly.Store(!rule.edits)
+	defer r.readOnly.Store(false)
+
+	// Snapshot the file so edits outside the verb's scope can be reverted.
+	before, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return Result{Directive: d, Status: StatusFailed, Reason: ReasonPrompt, Err: err}, err
+	}
+	// Only import markers the agent adds are resolved; keep what was there.
+	imports := importsEdit{file: importsFile(rule, sourceFile), before: before}
+	if imports.file != "" && imports.file != sourceFile {
+		imports.before, _ = os.ReadFile(imports.file)
+	}
+
+	data, err := r.promptData(ctx, sourceFile, d)
+	if err != nil {
+		return Result{Directive: d, Status: StatusFailed, Reason: ReasonPrompt, Err: err}, err
+	}
+	if rule.source == scopeEdit && data.Language == "go" && asksForFix(d) {
+		r.diagnose(ctx, sourceFile, d, &data)
+	}
+	r.clearLSPErrors(sourceFile)
+	text, err := r.opts.Prompts.Directive(data)
+	if err != nil {
+		return Result{Directive: d, Status: StatusFailed, Reason: ReasonPrompt, Err: err}, err
+	}
+	answer, err := r.prompt(ctx, sessionID, data, text)
+	if err != nil {
+		var sessErr *SessionError
+		if errors.As(err, &sessErr) {
+			return Result{Directive: d, Status: StatusFailed, Reason: ReasonSession, Err: err}, nil
+		}
+		return Result{Directive: d, Status: StatusFailed, Reason: ReasonPrompt, Err: err}, err
+	}
+
+	if err := checkScope(d.Verb, rule, sourceFile, before); err != nil {
+		return Result{Directive: d, Status: StatusFailed, Reason: ReasonScope, Err: err}, nil
+	}
+
+	result := Result{Directive: d, Status: StatusSucceeded}
+	if !rule.edits {
+		result.Report = answer
+		if err := r.report(sourceFile, d, answer); err != nil {
+			return Result{Directive: d, Status: StatusFailed, Reason: ReasonReport, Err: err, Report: answer}, nil
+		}
+		return result, nil
+	}
+	checks, err := r.checks(sourceFile, d)
+	if err != nil {
+		return Result{Directive: d, Status: StatusFailed, Reason: ReasonValidation, Err: err}, nil
+	}
+
+	failures, err := r.validate(ctx, sessionID, sourceFile, imports, data, checks)
+	if r.runsTests(d) && DetectLanguage(sourceFile) == "go" {
+		switch {
+		case hasFailure(failures, validate.TestCheckName):
+			result.Tests = TestsFailed
+		case err == nil || len(failures) > 0:
+			result.Tests = TestsPassed
+		}
+	}
+	if err != nil {
+		// A cancelled context aborts the run; anything else only fails this directive.
+		result.Status = StatusFailed
+		result.Reason = ReasonValidation
+		if result.Tests == TestsFailed {
+			result.Reason = ReasonTests
+		}
+		result.Err = err
+		return result, ctx.Err()
+	}
+
+	// Repair rounds get the same scope as the first edit.
+	if err := checkScope(d.Verb, rule, sourceFile, before); err != nil {
+		return Result{Directive: d, Status: StatusFailed, Reason: ReasonScope, Err: err}, nil
+	}
+	return result, nil
+}
+
+// runsTests reports whether tests are run after the edit for d. Test
+// directives always run them.
+func (r *Runner) runsTests(d directive.AIDirective) bool {
+	return r.opts.Test || d.Verb == directive.VerbTest
+}
+
+// checks returns the checks to run against the edit made for d.
+func (r *Runner) checks(sourceFile string, d directive.AIDirective) ([]validate.Check, error) {
+	checks := r.opts.Checks
+	if !r.runsTests(d) {
+		return checks, nil
+	}
+
+	var tests []string
+	if r.opts.TestRelated && d.Name != "" {
+		related, err := validate.TestsReferencing(filepath.Dir(sourceFile), d.Name)
+		if err != nil {
+			return nil, fmt.Errorf("finding related tests: %w", err)
+		}
+		if len(related) == 0 {
+			print.Warning(r.out(), "No tests reference", d.Name+", running all package tests")
+		}
+		tests = related
+	}
+
+	return append(checks[:len(checks):len(checks)], validate.TestCheck(tests)), nil
+}
+
+// validate runs checks and asks the agent to repair failures until they pass
+// or the repair rounds are exhausted, fixing imports before each run. It
+// returns the failures from the last run.
+func (r *Runner) validate(ctx context.Context, sessionID, sourceFile string, imports importsEdit, data prompt.Directive, checks []validate.Check) ([]validate.Failure, error) {
+	if DetectLanguage(sourceFile) != "go" {
+		return nil, nil
+	}
+
+	for round := 0; ; round++ {
+		r.fixImports(imports)
+
+		if len(checks) == 0 {
+			return nil, nil
+		}
+		failures, err := validate.Run(ctx, r.opts.Dir, sourceFile, checks)
+		if err != nil {
+			return failures, fmt.Errorf("validating: %w", err)
+		}
+		// A failed build already reports what the language server would.
+		if !hasFailure(failures, "build") {
+			if report, ok := r.lspFailure(sourceFile, data); ok {
+				failures = append(failures, validate.Failure{Check: LSPCheckName, Output: report})
+			}
+		}
+		if len(failures) == 0 {
+			if round > 0 {
+				print.Success(r.out(), print.Wrap("✅ Validation passed after", fmt.Sprint(round), "repair round(s)"))
+			}
+			return nil, nil
+		}
+
+		for _, f := range failures {
+			print.Warningf(r.out(), print.Wrap("🧪 Check failed: %s"), f.Check)
+		}
+		if round >= r.opts.RepairRounds {
+			return failures, fmt.Errorf("validation failed after %d repair round(s)", round)
+		}
+
+		print.Info(r.out(), print.Wrap("🔧 Requesting repair", fmt.Sprintf("(%d/%d)", round+1, r.opts.RepairRounds)))
+		r.opts.Emitter.Emit(output.TypeDirectiveRepair, repairEvent(round+1, r.opts.RepairRounds, failures))
+		text, err := r.opts.Prompts.Repair(prompt.Repair{
+			Directive: data,
+			Round:     round + 1,
+			MaxRounds: r.opts.RepairRounds,
+			Failures:  validate.Report(failures),
+		})
+		if err != nil {
+			return failures, err
+		}
+		if _, err := r.prompt(ctx, sessionID, data, text); err != nil {
+			return failures, err
+		}
+	}
+}
+
+// importsEdit is the file whose imports are fixed after an edit, if any, and
+// its contents before the agent ran.
+type importsEdit struct {
+	file   string
+	before []byte
+}
+
+// fixImports cleans up the imports of the edited file when enabled. Failures
+// are reported but left for validation to surface to the agent; a file the
+// agent never created is skipped.
+func (r *Runner) fixImports(edit importsEdit) {
+	if !r.opts.FixImports || edit.file == "" {
+		return
+	}
+
+	res, err := importfix.FixFile(edit.file, importfix.Since(edit.before))
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		print.Warningf(r.out(), print.Wrap("📦 Could not fix imports: %s"), err)
+		return
+	}
+	if len(res.Added) > 0 {
+		print.Notef(r.out(), print.Wrap("📦 Added imports: %s"), strings.Join(res.Added, ", "))
+	} else if res.Changed {
+		print.Note(r.out(), print.WrapTop("📦 Fixed imports"))
+	}
+}
+
+func leftoverVerb(mode LeftoverMode) string {
+	if mode == LeftoverMark {
+		return "marked done"
+	}
+	return "stripped"
+}
+
+// writeFile replaces the contents of path, preserving its permissions.
+func writeFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, info.Mode().Perm())
+}
+
+// hasFailure reports whether failures contains a failure from the named check.
+func hasFailure(failures []validate.Failure, check string) bool {
+	for _, f := range failures {
+		if f.Check == check {
+			return true
+		}
+	}
+	return false
+}
+
+// directiveMetadataKey tags prompt parts with the ID of the directive they
+// belong to, so a resumed session can tell which directives were answered.
+const directiveMetadataKey = "chiselDirective"
+
+// prompt sends text for the directive described by data to the session,
+// waits for the agent to finish responding and returns its final text.
+func (r *Runner) prompt(ctx context.Context, sessionID string, data prompt.Directive, text string) (string, error) {
+	system, err := r.opts.Prompts.System(data)
+	if err != nil {
+		return "", err
+	}
+	params := opencode.SessionPromptParams{
+		Directory: opencode.String(r.opts.Dir),
+		System:    opencode.String(system),
+		Model: opencode.F(opencode.SessionPromptParamsModel{
+			ModelID:    opencode.String(r.opts.Model),
+			ProviderID: opencode.String(r.opts.Provider),
+		}),
+		Parts: opencode.F(
+			[]opencode.SessionPromptParamsPartUnion{
+				opencode.TextPartInputParam{
+					Type:     opencode.F(opencode.TextPartInputType("text")),
+					Text:     opencode.String(text),
+					Metadata: opencode.F(map[string]interface{}{directiveMetadataKey: data.ID}),
+				},
+			}),
+	}
+	if r.readOnly.Load() {
+		params.Tools = opencode.F(readOnlyTools())
+	}
+	rsp, err := r.client.Session.Prompt(ctx, sessionID, params)
+	if err != nil {
+		print.Error(r.out(), "err prompting:", err.Error())
+		return "", fmt.Errorf("prompting: %w", err)
+	}
+	if rsp == nil {
+		return "", nil
+	}
+	if rsp.Info.Error.Name != "" {
+		return "", &SessionError{Name: string(rsp.Info.Error.Name)}
+	}
+	return finalText(rsp.Parts), nil
+}
+
+// Completed returns the IDs of directives in sessionID whose prompts the agent
+// answered without error, for skipping them when a session is resumed.
+func (r *Runner) Completed(ctx context.Context, sessionID string) (map[string]bool, error) {
+	messages, err := r.client.Session.Messages(ctx, sessionID, opencode.SessionMessagesParams{
+		Directory: opencode.String(r.opts.Dir),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing messages: %w", err)
+	}
+
+	// Map user messages to the directive they carried, then look for a
+	// completed reply to each.
+	asked := map[string]string{}
+	done := map[string]bool{}
+	for _, m := range *messages {
+		switch msg := m.Info.AsUnion().(type) {
+		case opencode.UserMessage:
+			for _, part := range m.Parts {
+				metadata, ok := part.Metadata.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if id, ok := metadata[directiveMetadataKey].(string); ok {
+					asked[msg.ID] = id
+				}
+			}
+		case opencode.AssistantMessage:
+			id, ok := asked[msg.ParentID]
+			if ok && msg.Time.Completed > 0 && msg.Error.Name == "" {
+				done[id] = true
+			}
+		}
+	}
+	return done, nil
+}
+
+// NewRunID returns a short random identifier for a run.
+func NewRunID() string {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// PrintSummary writes a one-line outcome for each directive.
+func PrintSummary(w io.Writer, results []Result) {
+	if len(results) == 0 {
+		return
+	}
+	print.Info(w, print.WrapTop("Summary:"))
+	for _, res := range results {
+		line := fmt.Sprintf("%s (lines %d-%d)", res.Directive.Function, res.Directive.StartLine, res.Directive.EndLine)
+		switch res.Status {
+		case StatusSucceeded:
+			if res.Tests == TestsPassed {
+				line += " tests passed"
+			}
+			print.Success(w, " ", print.Tick, line)
+		case StatusSkipped:
+			print.Warning(w, " ", print.WarningSym, line, "skipped")
+		default:
+			msg := line
+			if res.Tests == TestsFailed {
+				msg += " tests failed"
+			}
+			if res.Err != nil {
+				msg += ": " + res.Err.Error()
+			}
+			print.Error(w, " ", print.Cross, msg)
+		}
+	}
+}
+
+// Failed returns the number of results with StatusFailed.
+func Failed(results []Result) int {
+	n := 0
+	for _, res := range results {
+		if res.Status == StatusFailed {
+			n++
+		}
+	}
+	return n
+}
+
+// DetectLanguage returns the fenced code block language for filePath.
+func DetectLanguage(filePath string) string {
+	ext := filepath.Ext(filePath)
+	switch ext {
+	case ".go":
+		return "go"
+	default:
+		return ""
+	}
+}
+
+// EmitSummary emits the outcome of every directive as a single summary event.
+func EmitSummary(em *output.Emitter, results []Result) {
+	em.SetDirective("")
+	em.Emit(output.TypeSummary, Summarize(results))
+}
+
+// Summarize converts results to their machine-readable form.
+func Summarize(results []Result) output.Summary {
+	summary := output.Summary{Results: []output.Result{}}
+	for _, res := range results {
+		summary.Results = append(summary.Results, resultEvent(res))
+		if res.Status == StatusFailed {
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+func directiveEvent(sourceFile string, d directive.AIDirective) output.Directive {
+	prompt, _ := d.Prompt()
+	return output.Directive{
+		ID:        d.ID(),
+		Function:  d.Function,
+		File:      sourceFile,
+		StartLine: d.StartLine,
+		EndLine:   d.EndLine,
+		Prompt:    prompt,
+	}
+}
+
+func resultEvent(res Result) output.Result {
+	ev := output.Result{
+		ID:       res.Directive.ID(),
+		Function: res.Directive.Function,
+		Status:   string(res.Status),
+		Tests:    string(res.Tests),
+		Reason:   res.Reason,
+		Report:   res.Report,
+	}
+	if res.Err != nil {
+		ev.Error = res.Err.Error()
+	}
+	return ev
+}
+
+func repairEvent(round, max int, failures []validate.Failure) output.Repair {
+	ev := output.Repair{Round: round, Max: max}
+	for _, f := range failures {
+		ev.Failures = append(ev.Failures, output.Failure{Check: f.Check, Output: f.Output})
+	}
+	return ev
+}
@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+)
+
+func TestFixImportsByVerb(t *testing.T) {
+	const (
+		source = "package svc\n\nfunc Parse(s string) int {\n\tn, _ := strconv.Atoi(s) // TODO: add strconv\n\treturn n\n}\n"
+		test   = "package svc\n\nimport \"testing\"\n\nfunc TestParse(t *testing.T) {\n\t_ = Parse(strings.TrimSpace(\" 1 \")) // TODO: add strings\n}\n"
+	)
+	tests := []struct {
+		verb       directive.Verb
+		wantSource bool
+		wantTest   bool
+	}{
+		{verb: directive.VerbEdit, wantSource: true},
+		{verb: directive.VerbFix, wantSource: true},
+		{verb: directive.VerbTest, wantTest: true},
+		{verb: directive.VerbDoc},
+		{verb: directive.VerbReview},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.verb), func(t *testing.T) {
+			dir := t.TempDir()
+			sourceFile := filepath.Join(dir, "svc.go")
+			for path, content := range map[string]string{
+				filepath.Join(dir, "go.mod"): "module example.com/svc\n\ngo 1.22\n",
+				sourceFile:                   source,
+				TestFile(sourceFile):         test,
+			} {
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := New(nil, Options{FixImports: true, Out: io.Discard})
+			r.fixImports(importsEdit{file: importsFile(verbScopes[tt.verb], sourceFile)})
+
+			gotSource, _ := os.ReadFile(sourceFile)
+			if fixed := strings.Contains(string(gotSource), `import "strconv"`); fixed != tt.wantSource {
+				t.Errorf("source imports fixed = %v, want %v:\n%s", fixed, tt.wantSource, gotSource)
+			}
+			gotTest, _ := os.ReadFile(TestFile(sourceFile))
+			if fixed := strings.Contains(string(gotTest), `"strings"`); fixed != tt.wantTest {
+				t.Errorf("test imports fixed = %v, want %v:\n%s", fixed, tt.wantTest, gotTest)
+			}
+		})
+	}
+}
+
+func TestFixImportsMissingTestFile(t *testing.T) {
+	sourceFile := filepath.Join(t.TempDir(), "svc.go")
+	var out strings.Builder
+	r := New(nil, Options{FixImports: true, Out: &out})
+	r.fixImports(importsEdit{file: importsFile(verbScopes[directive.VerbTest], sourceFile)})
+	if out.Len() > 0 {
+		t.Errorf("expected a missing test file to be skipped quietly, got %q", out.String())
+	}
+}
@@ -0,0 +1,148 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+)
+
+// sourceScope says how much of the directive's source file a verb may change.
+type sourceScope int
+
+const (
+	// scopeEdit leaves the edit to the prompt's scope rules.
+	scopeEdit sourceScope = iota
+	// scopeComments allows changes to comments only.
+	scopeComments
+	// scopeReadOnly allows no changes.
+	scopeReadOnly
+)
+
+// scope is the edit-scope rule for a verb.
+type scope struct {
+	source sourceScope
+	// edits reports whether the verb changes code that should be validated.
+	edits bool
+}
+
+// verbScopes lists the verbs chisel understands.
+var verbScopes = map[directive.Verb]scope{
+	directive.VerbEdit:    {source: scopeEdit, edits: true},
+	directive.VerbFix:     {source: scopeEdit, edits: true},
+	directive.VerbTest:    {source: scopeReadOnly, edits: true},
+	directive.VerbDoc:     {source: scopeComments, edits: true},
+	directive.VerbExplain: {source: scopeReadOnly},
+	directive.VerbReview:  {source: scopeReadOnly},
+}
+
+// ScopeError reports an edit outside what a directive's verb allows.
+type ScopeError struct {
+	Verb directive.Verb
+	File string
+
+	source sourceScope
+}
+
+func (e *ScopeError) Error() string {
+	switch {
+	case e.source == scopeComments:
+		return fmt.Sprintf("@ai:%s may only change comments, but code in %s changed; the edit was reverted", e.Verb, e.File)
+	case verbScopes[e.Verb].source != e.source:
+		return fmt.Sprintf("read-only mode may not change %s; the edit was reverted", e.File)
+	}
+	return fmt.Sprintf("@ai:%s may not change %s; the edit was reverted", e.Verb, e.File)
+}
+
+// TestFile returns the sibling _test.go file of a Go source file.
+func TestFile(sourceFile string) string {
+	if strings.HasSuffix(sourceFile, "_test.go") {
+		return sourceFile
+	}
+	return strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile)) + "_test.go"
+}
+
+// importsFile returns the file whose imports are fixed after an edit under
+// rule: the source file when the verb may edit it, the sibling test file when
+// the source is read-only but the verb still edits (as tests do), and "" for
+// comment-only edits, which must leave imports alone.
+func importsFile(rule scope, sourceFile string) string {
+	switch {
+	case !rule.edits || rule.source == scopeComments:
+		return ""
+	case rule.source == scopeReadOnly:
+		return TestFile(sourceFile)
+	}
+	return sourceFile
+}
+
+// checkScope compares sourceFile with its contents before the agent ran and
+// restores them if rule did not allow the change.
+func checkScope(verb directive.Verb, rule scope, sourceFile string, before []byte) error {
+	if rule.source == scopeEdit {
+		return nil
+	}
+
+	after, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return err
+	}
+	allowed := bytes.Equal(before, after)
+	if !allowed && rule.source == scopeComments {
+		allowed = DetectLanguage(sourceFile) == "go" && sameCode(before, after)
+	}
+	if allowed {
+		return nil
+	}
+
+	if err := writeFile(sourceFile, before); err != nil {
+		return fmt.Errorf("restoring %s: %w", sourceFile, err)
+	}
+	return &ScopeError{Verb: verb, File: sourceFile, source: rule.source}
+}
+
+// sameCode reports whether two Go sources differ only in comments and
+// formatting, by comparing their token streams.
+func sameCode(a, b []byte) bool {
+	ta, tb := tokens(a), tokens(b)
+	if len(ta) != len(tb) {
+		return false
+	}
+	for i := range ta {
+		if ta[i] != tb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type tok struct {
+	tok token.Token
+	lit string
+}
+
+func tokens(src []byte) []tok {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+
+	var s scanner.Scanner
+	s.Init(file, src, nil, 0)
+
+	var out []tok
+	for {
+		_, t, lit := s.Scan()
+		if t == token.EOF {
+			return out
+		}
+		if t == token.SEMICOLON {
+			// Automatic semicolons carry "\n" as their literal; treat all alike.
+			lit = ""
+		}
+		out = append(out, tok{t, lit})
+	}
+}
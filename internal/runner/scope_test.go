@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+)
+
+func TestCheckScope(t *testing.T) {
+	before := "package main\n\n// @ai:doc\nfunc add(a, b int) int {\n\treturn a + b\n}\n"
+	tests := []struct {
+		name   string
+		verb   directive.Verb
+		after  string
+		reject bool
+	}{
+		{
+			name:  "edit may change code",
+			verb:  directive.VerbEdit,
+			after: "package main\n\nfunc add(a, b int) int {\n\treturn b + a\n}\n",
+		},
+		{
+			name:  "doc may change comments",
+			verb:  directive.VerbDoc,
+			after: "package main\n\n// add returns the sum of a and b.\nfunc add(a, b int) int {\n\treturn a + b // no overflow check\n}\n",
+		},
+		{
+			name:   "doc may not change code",
+			verb:   directive.VerbDoc,
+			after:  "package main\n\n// add returns the sum of a and b.\nfunc add(a, b int) int {\n\treturn b + a\n}\n",
+			reject: true,
+		},
+		{
+			name:  "review leaves the file alone",
+			verb:  directive.VerbReview,
+			after: before,
+		},
+		{
+			name:   "test may not change source",
+			verb:   directive.VerbTest,
+			after:  "package main\n\n// add is tested.\nfunc add(a, b int) int {\n\treturn a + b\n}\n",
+			reject: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "add.go")
+			if err := os.WriteFile(path, []byte(tt.after), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := checkScope(tt.verb, verbScopes[tt.verb], path, []byte(before))
+			var scopeErr *ScopeError
+			if got := errors.As(err, &scopeErr); got != tt.reject {
+				t.Fatalf("expected rejection %v, got %v", tt.reject, err)
+			}
+
+			want := tt.after
+			if tt.reject {
+				want = before
+			}
+			if got, _ := os.ReadFile(path); string(got) != want {
+				t.Errorf("file content:\n  expected: %q\n  got:      %q", want, got)
+			}
+		})
+	}
+}
+
+func TestTestFile(t *testing.T) {
+	if got := TestFile("pkg/svc.go"); got != "pkg/svc_test.go" {
+		t.Errorf("TestFile(svc.go) = %q", got)
+	}
+	if got := TestFile("pkg/svc_test.go"); got != "pkg/svc_test.go" {
+		t.Errorf("TestFile(svc_test.go) = %q", got)
+	}
+}
@@ -0,0 +1,190 @@
+package serve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// NewHandler exposes m over HTTP:
+//
+//	POST /jobs               submit a Request; relative files resolve against dir
+//	GET  /jobs               list jobs
+//	GET  /jobs/{id}          get a job
+//	GET  /jobs/{id}/events   stream the job's events as server-sent events
+//	POST /jobs/{id}/cancel   cancel a job
+//
+// Files outside dir are rejected, as are requests a web page could forge; see
+// guard.
+func NewHandler(m *Manager, dir string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /jobs", func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+			return
+		}
+		file, err := resolve(dir, req.File)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+		req.File = file
+
+		job, err := m.Submit(req)
+		if errors.Is(err, ErrBusy) {
+			httpError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+	})
+
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, m.List())
+	})
+
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := m.Get(r.PathValue("id"))
+		if !ok {
+			httpError(w, http.StatusNotFound, errors.New("job not found"))
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	})
+
+	mux.HandleFunc("GET /jobs/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := m.Get(r.PathValue("id"))
+		if !ok {
+			httpError(w, http.StatusNotFound, errors.New("job not found"))
+			return
+		}
+		streamEvents(w, r, job.log)
+	})
+
+	mux.HandleFunc("POST /jobs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		if !m.Cancel(r.PathValue("id")) {
+			httpError(w, http.StatusNotFound, errors.New("job not found"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return guard(mux)
+}
+
+// guard protects the API from web pages the user visits. The API starts agent
+// edits, so a cross-site POST must not reach it: requests must name a local
+// host, so DNS rebinding fails; must not come from another origin; and POSTs
+// must send JSON, which browsers cannot do cross-origin without a preflight
+// this server never approves.
+func guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !localHost(r.Host) {
+			httpError(w, http.StatusForbidden, fmt.Errorf("host %q is not allowed", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				httpError(w, http.StatusForbidden, fmt.Errorf("origin %q is not allowed", origin))
+				return
+			}
+		}
+		if r.Method == http.MethodPost {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				httpError(w, http.StatusUnsupportedMediaType, errors.New("Content-Type must be application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// localHost reports whether host, a Host header, names this machine by IP
+// address or as localhost rather than through a domain name.
+func localHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return host == "localhost" || net.ParseIP(host) != nil
+}
+
+// streamEvents writes every event in log as a server-sent event named after
+// its type, following the log until the job finishes or the client leaves.
+func streamEvents(w http.ResponseWriter, r *http.Request, log *eventLog) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sent := 0
+	for {
+		lines, closed, changed := log.since(sent)
+		for _, line := range lines {
+			var event struct {
+				Type string `json:"type"`
+			}
+			json.Unmarshal(line, &event)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, strings.TrimSpace(string(line)))
+		}
+		sent += len(lines)
+		flusher.Flush()
+
+		if closed {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// resolve makes file absolute relative to dir and checks that it is inside dir.
+func resolve(dir, file string) (string, error) {
+	if file == "" {
+		return "", errors.New("file is required")
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(root, file)
+	}
+	file = filepath.Clean(file)
+
+	rel, err := filepath.Rel(root, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside %s", file, root)
+	}
+	return file, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
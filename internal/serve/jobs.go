@@ -0,0 +1,239 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thomasgormley/chisel/internal/output"
+	"github.com/thomasgormley/chisel/internal/runner"
+)
+
+// JobStatus describes where a job is in its lifecycle.
+type JobStatus string
+
+const (
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// Request asks for directives in File to be run. Directives selects them by
+// ID; empty runs every directive in the file.
+type Request struct {
+	File       string   `json:"file"`
+	Directives []string `json:"directives,omitempty"`
+}
+
+// RunFunc processes a request, writing its progress to events as NDJSON
+// lines in the shape output.Emitter produces.
+type RunFunc func(ctx context.Context, req Request, events io.Writer) ([]runner.Result, error)
+
+// ErrBusy is returned when a job is already running for the requested file.
+var ErrBusy = errors.New("a job is already running for this file")
+
+// Job is a single submitted request and the events it has produced.
+type Job struct {
+	ID       string          `json:"id"`
+	Request  Request         `json:"request"`
+	Status   JobStatus       `json:"status"`
+	Created  time.Time       `json:"created"`
+	Finished time.Time       `json:"finished,omitzero"`
+	Results  []output.Result `json:"results,omitempty"`
+	Error    string          `json:"error,omitempty"`
+
+	cancel context.CancelFunc
+	log    *eventLog
+}
+
+// defaultHistory is how many finished jobs a Manager keeps by default.
+const defaultHistory = 100
+
+// Manager runs jobs and keeps the most recent ones around for inspection.
+// Only one job runs per file at a time, since concurrent edits to one file
+// would conflict.
+type Manager struct {
+	run     RunFunc
+	ctx     context.Context
+	history int
+
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	nextID int
+	wg     sync.WaitGroup
+}
+
+// ManagerOption is a functional option for configuring a Manager.
+type ManagerOption func(*Manager)
+
+// WithHistory keeps at most n finished jobs, forgetting the oldest first, so
+// a long-running server does not hold every job it ever ran.
+func WithHistory(n int) ManagerOption {
+	return func(m *Manager) {
+		m.history = n
+	}
+}
+
+// NewManager creates a Manager that runs jobs with run. Jobs are cancelled
+// when ctx is.
+func NewManager(ctx context.Context, run RunFunc, opts ...ManagerOption) *Manager {
+	m := &Manager{run: run, ctx: ctx, history: defaultHistory, jobs: map[string]*Job{}}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Submit starts a job for req.
+func (m *Manager) Submit(req Request) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, j := range m.jobs {
+		if j.Status == JobRunning && j.Request.File == req.File {
+			return Job{}, ErrBusy
+		}
+	}
+
+	m.nextID++
+	ctx, cancel := context.WithCancel(m.ctx)
+	job := &Job{
+		ID:      fmt.Sprintf("job-%d", m.nextID),
+		Request: req,
+		Status:  JobRunning,
+		Created: time.Now(),
+		cancel:  cancel,
+		log:     newEventLog(),
+	}
+	m.jobs[job.ID] = job
+
+	m.wg.Go(func() { m.execute(ctx, job) })
+	return *job, nil
+}
+
+func (m *Manager) execute(ctx context.Context, job *Job) {
+	defer job.cancel()
+	defer job.log.close()
+
+	results, err := m.run(ctx, job.Request, job.log)
+	runner.EmitSummary(output.NewEmitter(job.log), results)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.Finished = time.Now()
+	job.Results = runner.Summarize(results).Results
+	switch {
+	case ctx.Err() != nil:
+		job.Status = JobCancelled
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	case runner.Failed(results) > 0:
+		job.Status = JobFailed
+	default:
+		job.Status = JobSucceeded
+	}
+	m.evict()
+}
+
+// evict forgets the oldest finished jobs beyond the history limit. m.mu must
+// be held.
+func (m *Manager) evict() {
+	var finished []*Job
+	for _, j := range m.jobs {
+		if j.Status != JobRunning {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= m.history {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool { return finished[i].Finished.Before(finished[k].Finished) })
+	for _, j := range finished[:len(finished)-m.history] {
+		delete(m.jobs, j.ID)
+	}
+}
+
+// Wait blocks until every submitted job has finished.
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+// List returns every job, oldest first.
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, *j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Created.Before(jobs[k].Created) })
+	return jobs
+}
+
+// Get returns the job with id.
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// Cancel stops the job with id. It reports false if there is no such job.
+func (m *Manager) Cancel(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if ok {
+		j.cancel()
+	}
+	return ok
+}
+
+// eventLog keeps every event line a job emits so late subscribers see the
+// whole history, and wakes subscribers as lines arrive.
+type eventLog struct {
+	mu      sync.Mutex
+	lines   [][]byte
+	changed chan struct{}
+	closed  bool
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{changed: make(chan struct{})}
+}
+
+// Write records one event line. The emitter writes each event in one call.
+func (l *eventLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, append([]byte(nil), p...))
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return len(p), nil
+}
+
+func (l *eventLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// since returns the lines after the first n, whether the log is finished, and
+// a channel closed when more arrive.
+func (l *eventLog) since(n int) ([][]byte, bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lines[n:], l.closed, l.changed
+}
@@ -0,0 +1,203 @@
+package serve
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/output"
+	"github.com/thomasgormley/chisel/internal/runner"
+)
+
+func TestJobLifecycle(t *testing.T) {
+	release := make(chan struct{})
+	run := func(ctx context.Context, req Request, events io.Writer) ([]runner.Result, error) {
+		output.NewEmitter(events).Emit(output.TypeFileEdited, output.FileEdited{File: req.File})
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return []runner.Result{{Directive: directive.AIDirective{Function: "main"}, Status: runner.StatusSucceeded}}, nil
+	}
+
+	dir := t.TempDir()
+	m := NewManager(context.Background(), run)
+	srv := httptest.NewServer(NewHandler(m, dir))
+	defer srv.Close()
+
+	rsp, err := http.Post(srv.URL+"/jobs", "application/json", strings.NewReader(`{"file":"main.go"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job Job
+	json.NewDecoder(rsp.Body).Decode(&job)
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rsp.StatusCode)
+	}
+	if job.Request.File != filepath.Join(dir, "main.go") {
+		t.Errorf("expected file resolved against dir, got %s", job.Request.File)
+	}
+
+	rsp, err = http.Post(srv.URL+"/jobs", "application/json", strings.NewReader(`{"file":"main.go"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 for a second job on the same file, got %d", rsp.StatusCode)
+	}
+
+	events, err := http.Get(srv.URL + "/jobs/" + job.ID + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Body.Close()
+	close(release)
+
+	var types []string
+	scanner := bufio.NewScanner(events.Body)
+	for scanner.Scan() {
+		if typ, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			types = append(types, typ)
+		}
+	}
+	expected := []string{output.TypeFileEdited, output.TypeSummary}
+	if strings.Join(types, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected events %v, got %v", expected, types)
+	}
+
+	m.Wait()
+	got, ok := m.Get(job.ID)
+	if !ok {
+		t.Fatal("job not found")
+	}
+	if got.Status != JobSucceeded || len(got.Results) != 1 {
+		t.Errorf("expected a succeeded job with 1 result, got %s with %d", got.Status, len(got.Results))
+	}
+}
+
+func TestCancel(t *testing.T) {
+	run := func(ctx context.Context, req Request, events io.Writer) ([]runner.Result, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	m := NewManager(context.Background(), run)
+	job, err := m.Submit(Request{File: "main.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Cancel(job.ID) {
+		t.Fatal("expected job to be found")
+	}
+	m.Wait()
+
+	got, _ := m.Get(job.ID)
+	if got.Status != JobCancelled {
+		t.Errorf("expected cancelled, got %s", got.Status)
+	}
+	if m.Cancel("job-99") {
+		t.Error("expected unknown job not to be found")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{file: "main.go", want: "/repo/main.go"},
+		{file: "/repo/pkg/a.go", want: "/repo/pkg/a.go"},
+		{file: "../other/main.go", wantErr: true},
+		{file: "/etc/passwd", wantErr: true},
+		{file: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolve("/repo", tt.file)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolve(%q) error = %v, wantErr %v", tt.file, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("resolve(%q) = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		host        string
+		origin      string
+		contentType string
+		want        int
+	}{
+		{name: "json post", method: http.MethodPost, contentType: "application/json; charset=utf-8", want: http.StatusOK},
+		{name: "same origin", method: http.MethodPost, origin: "http://127.0.0.1:3377", contentType: "application/json", want: http.StatusOK},
+		{name: "form post", method: http.MethodPost, contentType: "application/x-www-form-urlencoded", want: http.StatusUnsupportedMediaType},
+		{name: "text post", method: http.MethodPost, contentType: "text/plain", want: http.StatusUnsupportedMediaType},
+		{name: "foreign origin", method: http.MethodPost, origin: "https://evil.example", contentType: "application/json", want: http.StatusForbidden},
+		{name: "foreign origin get", method: http.MethodGet, origin: "https://evil.example", want: http.StatusForbidden},
+		{name: "rebound host", method: http.MethodGet, host: "evil.example:3377", want: http.StatusForbidden},
+		{name: "localhost", method: http.MethodGet, host: "localhost:3377", want: http.StatusOK},
+		{name: "get", method: http.MethodGet, want: http.StatusOK},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/jobs", strings.NewReader(`{}`))
+			req.Host = "127.0.0.1:3377"
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			guard(ok).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	run := func(ctx context.Context, req Request, events io.Writer) ([]runner.Result, error) {
+		return nil, nil
+	}
+	m := NewManager(context.Background(), run, WithHistory(2))
+	var ids []string
+	for _, file := range []string{"a.go", "b.go", "c.go"} {
+		job, err := m.Submit(Request{File: file})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Wait()
+		ids = append(ids, job.ID)
+	}
+
+	if _, ok := m.Get(ids[0]); ok {
+		t.Error("expected the oldest finished job to be forgotten")
+	}
+	for _, id := range ids[1:] {
+		if _, ok := m.Get(id); !ok {
+			t.Errorf("expected %s to be kept", id)
+		}
+	}
+	if got := len(m.List()); got != 2 {
+		t.Errorf("expected 2 jobs, got %d", got)
+	}
+}
@@ -0,0 +1,85 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sst/opencode-sdk-go"
+)
+
+// TitlePrefix marks sessions created by chisel so they can be told apart from
+// sessions created by other clients of the same server.
+const TitlePrefix = "chisel: "
+
+// Title returns the title for a session processing count directives in file.
+func Title(file string, count int) string {
+	noun := "directives"
+	if count == 1 {
+		noun = "directive"
+	}
+	return fmt.Sprintf("%s%s (%d %s)", TitlePrefix, filepath.Base(file), count, noun)
+}
+
+// WatchTitle returns the title for a session created by watch mode.
+func WatchTitle(dir string) string {
+	return TitlePrefix + "watch " + dir
+}
+
+// ServeTitle returns the title for the session shared by a serve daemon's
+// jobs.
+func ServeTitle(dir string) string {
+	return TitlePrefix + "serve " + dir
+}
+
+// IsChisel reports whether session was created by chisel.
+func IsChisel(session opencode.Session) bool {
+	return strings.HasPrefix(session.Title, TitlePrefix)
+}
+
+// Updated returns the time session was last updated.
+func Updated(session opencode.Session) time.Time {
+	return time.UnixMilli(int64(session.Time.Updated))
+}
+
+// List returns the chisel-created sessions for dir, most recently updated first.
+func List(ctx context.Context, client *opencode.Client, dir string) ([]opencode.Session, error) {
+	params := opencode.SessionListParams{}
+	if dir != "" {
+		params.Directory = opencode.String(dir)
+	}
+	all, err := client.Session.List(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("listing sessions: %w", err)
+	}
+
+	var sessions []opencode.Session
+	for _, s := range *all {
+		if IsChisel(s) {
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Time.Updated > sessions[j].Time.Updated
+	})
+	return sessions, nil
+}
+
+// Delete removes the session with id.
+func Delete(ctx context.Context, client *opencode.Client, dir, id string) error {
+	params := opencode.SessionDeleteParams{}
+	if dir != "" {
+		params.Directory = opencode.String(dir)
+	}
+	ok, err := client.Session.Delete(ctx, id, params)
+	if err != nil {
+		return fmt.Errorf("deleting session %s: %w", id, err)
+	}
+	if ok == nil || !*ok {
+		return fmt.Errorf("deleting session %s: server did not confirm", id)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package sessions
+
+import (
+	"testing"
+
+	"github.com/sst/opencode-sdk-go"
+)
+
+func TestTitle(t *testing.T) {
+	tests := []struct {
+		file     string
+		count    int
+		expected string
+	}{
+		{file: "internal/service/user.go", count: 1, expected: "chisel: user.go (1 directive)"},
+		{file: "main.go", count: 3, expected: "chisel: main.go (3 directives)"},
+	}
+
+	for _, tt := range tests {
+		if got := Title(tt.file, tt.count); got != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, got)
+		}
+	}
+}
+
+func TestIsChisel(t *testing.T) {
+	if !IsChisel(opencode.Session{Title: Title("main.go", 2)}) {
+		t.Error("expected titled session to be recognised")
+	}
+	if !IsChisel(opencode.Session{Title: WatchTitle(".")}) {
+		t.Error("expected watch session to be recognised")
+	}
+	if IsChisel(opencode.Session{Title: "New session - 2025-01-01"}) {
+		t.Error("expected other sessions to be ignored")
+	}
+}
@@ -0,0 +1,88 @@
+package validate
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a compiler or vet message about a position in a file.
+type Diagnostic struct {
+	Check   string
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+// diagnosticPattern matches "file.go:line:col: message" lines, which vet
+// prefixes with "vet: " for type errors.
+var diagnosticPattern = regexp.MustCompile(`^(?:vet: )?(\S+\.go):(\d+)(?::(\d+))?: (.+)$`)
+
+// Diagnostics builds and vets the package of file from dir and returns the
+// messages about file between lines start and end, inclusive. Messages both
+// checks report are returned once.
+func Diagnostics(ctx context.Context, dir, file string, start, end uint) ([]Diagnostic, error) {
+	checks, err := Lookup([]string{"build", "vet"})
+	if err != nil {
+		return nil, err
+	}
+	failures, err := Run(ctx, dir, file, checks)
+	if err != nil {
+		return nil, err
+	}
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var diagnostics []Diagnostic
+	seen := map[string]bool{}
+	for _, f := range failures {
+		for _, d := range ParseDiagnostics(f.Check, dir, f.Output) {
+			if d.File != absFile || d.Line < int(start) || d.Line > int(end) {
+				continue
+			}
+			key := strconv.Itoa(d.Line) + ":" + strconv.Itoa(d.Column) + ":" + d.Message
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	return diagnostics, nil
+}
+
+// ParseDiagnostics extracts the positioned messages from the output of a check
+// run in dir, with file paths made absolute. Indented lines continue the
+// message before them; anything else is ignored.
+func ParseDiagnostics(check, dir, output string) []Diagnostic {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		absDir = dir
+	}
+
+	var diagnostics []Diagnostic
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "\t") && len(diagnostics) > 0 {
+			last := &diagnostics[len(diagnostics)-1]
+			last.Message += "\n" + strings.TrimSpace(line)
+			continue
+		}
+		m := diagnosticPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		d := Diagnostic{Check: check, File: m[1], Message: m[4]}
+		if !filepath.IsAbs(d.File) {
+			d.File = filepath.Join(absDir, d.File)
+		}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
@@ -0,0 +1,79 @@
+package validate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// TestCheckName is the name reported for failures from TestCheck.
+const TestCheckName = "test"
+
+// TestCheck returns a check that runs the package's tests, limited to the
+// given test names when any are provided.
+func TestCheck(tests []string) Check {
+	args := []string{"go", "test", PackagePlaceholder}
+	if len(tests) > 0 {
+		quoted := make([]string, len(tests))
+		for i, name := range tests {
+			quoted[i] = regexp.QuoteMeta(name)
+		}
+		args = append(args, "-run", "^("+strings.Join(quoted, "|")+")$")
+	}
+	return Check{Name: TestCheckName, Args: args}
+}
+
+// TestsReferencing returns the names of Test functions in the _test.go files
+// of dir whose bodies mention identifier name.
+func TestsReferencing(dir, name string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*_test.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	seen := map[string]bool{}
+	for _, path := range files {
+		file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || fn.Body == nil || !strings.HasPrefix(fn.Name.Name, "Test") {
+				continue
+			}
+			if references(fn.Body, name) {
+				seen[fn.Name.Name] = true
+			}
+		}
+	}
+
+	tests := make([]string, 0, len(seen))
+	for test := range seen {
+		tests = append(tests, test)
+	}
+	sort.Strings(tests)
+	return tests, nil
+}
+
+// references reports whether node contains an identifier called name.
+func references(node ast.Node, name string) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if found {
+			return false
+		}
+		if ident, ok := n.(*ast.Ident); ok && ident.Name == name {
+			found = true
+		}
+		return true
+	})
+	return found
+}
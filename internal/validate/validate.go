@@ -0,0 +1,134 @@
+package validate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Placeholders substituted into check arguments before they run.
+const (
+	FilePlaceholder    = "{file}"
+	PackagePlaceholder = "{pkg}"
+)
+
+// Check is a single command run against an edited file once a directive
+// completes. A non-zero exit status marks the check as failed.
+type Check struct {
+	Name string
+	Args []string
+}
+
+// builtinChecks are the checks selectable by name.
+var builtinChecks = map[string]Check{
+	"gofmt": {Name: "gofmt", Args: []string{"gofmt", "-d", FilePlaceholder}},
+	"build": {Name: "build", Args: []string{"go", "build", "-o", os.DevNull, PackagePlaceholder}},
+	"vet":   {Name: "vet", Args: []string{"go", "vet", PackagePlaceholder}},
+}
+
+// DefaultChecks lists the checks run when none are configured.
+var DefaultChecks = []string{"gofmt", "build", "vet"}
+
+// Lookup resolves check names to their built-in definitions.
+func Lookup(names []string) ([]Check, error) {
+	var checks []Check
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		check, ok := builtinChecks[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown check %q", name)
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// Failure records the output of a check that did not pass.
+type Failure struct {
+	Check  string
+	Output string
+}
+
+// Run executes each check from dir against file and returns the failures.
+// An error is returned only when a check could not be started at all.
+func Run(ctx context.Context, dir, file string, checks []Check) ([]Failure, error) {
+	relFile, err := relativeTo(dir, file)
+	if err != nil {
+		return nil, err
+	}
+	pkg := "./" + filepath.ToSlash(filepath.Dir(relFile))
+
+	var failures []Failure
+	for _, check := range checks {
+		if len(check.Args) == 0 {
+			continue
+		}
+		args := expand(check.Args, relFile, pkg)
+
+		var out bytes.Buffer
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Dir = dir
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+
+		if err := cmd.Run(); err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				return failures, fmt.Errorf("running %s: %w", check.Name, err)
+			}
+			failures = append(failures, Failure{
+				Check:  check.Name,
+				Output: strings.TrimSpace(out.String()),
+			})
+		}
+	}
+
+	return failures, nil
+}
+
+// Report formats failures as tagged blocks suitable for a repair prompt.
+func Report(failures []Failure) string {
+	var b strings.Builder
+	for i, f := range failures {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "<check name=%q>\n%s\n</check>", f.Check, f.Output)
+	}
+	return b.String()
+}
+
+// expand substitutes placeholders in each argument.
+func expand(args []string, file, pkg string) []string {
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		arg = strings.ReplaceAll(arg, FilePlaceholder, file)
+		arg = strings.ReplaceAll(arg, PackagePlaceholder, pkg)
+		expanded[i] = arg
+	}
+	return expanded
+}
+
+// relativeTo returns file relative to dir, resolving relative paths against
+// the working directory first.
+func relativeTo(dir, file string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absDir, absFile)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s against %s: %w", file, dir, err)
+	}
+	return rel, nil
+}
@@ -0,0 +1,170 @@
+package validate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	checks, err := Lookup([]string{"gofmt", " vet", ""})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(checks) != 2 {
+		t.Fatalf("expected 2 checks, got %d", len(checks))
+	}
+	if checks[0].Name != "gofmt" || checks[1].Name != "vet" {
+		t.Errorf("unexpected checks: %+v", checks)
+	}
+
+	if _, err := Lookup([]string{"lint"}); err == nil {
+		t.Error("expected error for unknown check")
+	}
+}
+
+func TestExpand(t *testing.T) {
+	got := expand([]string{"go", "vet", PackagePlaceholder, "--file=" + FilePlaceholder}, "pkg/a.go", "./pkg")
+	expected := []string{"go", "vet", "./pkg", "--file=pkg/a.go"}
+
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("arg[%d]: expected %q, got %q", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestRunGofmt(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	checks, _ := Lookup([]string{"gofmt"})
+
+	if err := os.WriteFile(file, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	failures, err := Run(context.Background(), dir, file, checks)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failures) != 0 {
+		t.Fatalf("expected no failures, got %+v", failures)
+	}
+
+	if err := os.WriteFile(file, []byte("package main\nfunc  main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	failures, err = Run(context.Background(), dir, file, checks)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failures) != 1 || failures[0].Check != "gofmt" {
+		t.Fatalf("expected a gofmt failure, got %+v", failures)
+	}
+}
+
+func TestReport(t *testing.T) {
+	got := Report([]Failure{
+		{Check: "build", Output: "a.go:1: undefined: x"},
+		{Check: "vet", Output: "a.go:2: unreachable code"},
+	})
+	expected := "<check name=\"build\">\na.go:1: undefined: x\n</check>\n\n<check name=\"vet\">\na.go:2: unreachable code\n</check>"
+
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestTestCheck(t *testing.T) {
+	all := TestCheck(nil)
+	if got := len(all.Args); got != 3 {
+		t.Fatalf("expected 3 args without filter, got %d: %v", got, all.Args)
+	}
+
+	narrowed := TestCheck([]string{"TestA", "TestB"})
+	if got := narrowed.Args[len(narrowed.Args)-1]; got != "^(TestA|TestB)$" {
+		t.Errorf("Expected %q, got %q", "^(TestA|TestB)$", got)
+	}
+}
+
+func TestTestsReferencing(t *testing.T) {
+	dir := t.TempDir()
+	src := `package svc
+
+import "testing"
+
+func TestGetUser(t *testing.T) {
+	s := &Service{}
+	s.GetUser("1")
+}
+
+func TestDirect(t *testing.T) {
+	_ = GetUser
+}
+
+func TestOther(t *testing.T) {
+	_ = "GetUser"
+}
+
+func helper() {
+	GetUser()
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "svc_test.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := TestsReferencing(dir, "GetUser")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"TestDirect", "TestGetUser"}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("test[%d]: expected %q, got %q", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	output := "# example.com/app\n" +
+		"vet: ./main.go:4:2: undefined: x\n" +
+		"main.go:7: unreachable code\n" +
+		"main.go:9:3: cannot use y (variable of type int) as string value:\n" +
+		"\tneed conversion\n" +
+		"/abs/other.go:1:1: expected 'package'"
+	got := ParseDiagnostics("vet", "/repo", output)
+	want := []Diagnostic{
+		{Check: "vet", File: "/repo/main.go", Line: 4, Column: 2, Message: "undefined: x"},
+		{Check: "vet", File: "/repo/main.go", Line: 7, Message: "unreachable code"},
+		{Check: "vet", File: "/repo/main.go", Line: 9, Column: 3, Message: "cannot use y (variable of type int) as string value:\nneed conversion"},
+		{Check: "vet", File: "/abs/other.go", Line: 1, Column: 1, Message: "expected 'package'"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("\n  expected: %+v\n  got:      %+v", want, got)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	src := "package main\n\nfunc main() {\n\t_ = undefinedA\n}\n\nfunc other() {\n\t_ = undefinedB\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n\ngo 1.22\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Diagnostics(context.Background(), dir, file, 3, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0].Line != 4 || got[0].Message != "undefined: undefinedA" {
+		t.Errorf("expected one diagnostic for line 4, got %+v", got)
+	}
+}
@@ -0,0 +1,51 @@
+package watch
+
+import (
+	"sync"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+)
+
+// Tracker remembers the directives seen in each file so that only newly added
+// ones are run. A directive stays known while it is in flight and after it is
+// processed, and is forgotten once it disappears from the file so that adding
+// it again runs it again.
+type Tracker struct {
+	mu   sync.Mutex
+	seen map[string]map[string]bool
+}
+
+// NewTracker creates an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{seen: map[string]map[string]bool{}}
+}
+
+// Seed records directives as already known without reporting them as new.
+func (t *Tracker) Seed(file string, directives []directive.AIDirective) {
+	t.Fresh(file, directives)
+}
+
+// Fresh returns the directives in file that have not been seen before and
+// records them as seen. Directives no longer present are forgotten.
+func (t *Tracker) Fresh(file string, directives []directive.AIDirective) []directive.AIDirective {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev := t.seen[file]
+	current := make(map[string]bool, len(directives))
+	var fresh []directive.AIDirective
+	for _, d := range directives {
+		k := d.ID()
+		if !prev[k] && !current[k] {
+			fresh = append(fresh, d)
+		}
+		current[k] = true
+	}
+
+	if len(current) == 0 {
+		delete(t.seen, file)
+	} else {
+		t.seen[file] = current
+	}
+	return fresh
+}
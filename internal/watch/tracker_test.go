@@ -0,0 +1,67 @@
+package watch
+
+import (
+	"testing"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+)
+
+func TestTrackerFresh(t *testing.T) {
+	first := directive.AIDirective{Function: "first", Comment: "// @ai implement first"}
+	second := directive.AIDirective{Function: "second", Comment: "// @ai implement second"}
+
+	tracker := NewTracker()
+	tracker.Seed("a.go", []directive.AIDirective{first})
+
+	if fresh := tracker.Fresh("a.go", []directive.AIDirective{first}); len(fresh) != 0 {
+		t.Fatalf("expected seeded directive to be known, got %d fresh", len(fresh))
+	}
+
+	fresh := tracker.Fresh("a.go", []directive.AIDirective{first, second})
+	if len(fresh) != 1 || fresh[0].Function != "second" {
+		t.Fatalf("expected only second to be fresh, got %+v", fresh)
+	}
+
+	if fresh := tracker.Fresh("a.go", []directive.AIDirective{first, second}); len(fresh) != 0 {
+		t.Fatalf("expected in-flight directives to be skipped, got %d fresh", len(fresh))
+	}
+
+	// Once removed from the file, adding the same directive again runs it again.
+	tracker.Fresh("a.go", []directive.AIDirective{first})
+	fresh = tracker.Fresh("a.go", []directive.AIDirective{first, second})
+	if len(fresh) != 1 || fresh[0].Function != "second" {
+		t.Fatalf("expected re-added second to be fresh, got %+v", fresh)
+	}
+
+	if fresh := tracker.Fresh("b.go", []directive.AIDirective{first}); len(fresh) != 1 {
+		t.Fatalf("expected directives in another file to be tracked separately, got %d fresh", len(fresh))
+	}
+}
+
+func TestTrackerSkipsDirectivesFromRun(t *testing.T) {
+	parser := directive.NewParser()
+	parse := func(src string) []directive.AIDirective {
+		t.Helper()
+		directives, err := parser.Parse([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return directives
+	}
+
+	tracker := NewTracker()
+	tracker.Seed("a.go", parse("package a\n\nfunc Load() {}\n"))
+
+	saved := parse("package a\n\nfunc Load() {\n\t// @ai read the config file\n}\n")
+	if fresh := tracker.Fresh("a.go", saved); len(fresh) != 1 {
+		t.Fatalf("expected the user's directive to be fresh, got %d", len(fresh))
+	}
+
+	// The run strips the directive and the agent leaves a note for later.
+	rewritten := parse("package a\n\nfunc Load() {\n\t// @ai TODO: handle a missing file\n\t_ = 1\n}\n")
+	tracker.Seed("a.go", rewritten)
+
+	if fresh := tracker.Fresh("a.go", rewritten); len(fresh) != 0 {
+		t.Fatalf("expected the run's own directive not to be run again, got %+v", fresh)
+	}
+}
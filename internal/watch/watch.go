@@ -0,0 +1,137 @@
+package watch
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// skipDirs are directory names never watched.
+var skipDirs = map[string]bool{
+	".git":     true,
+	"vendor":   true,
+	"testdata": true,
+}
+
+// Watch sends the path of each Go file under dir to changed once it has stopped
+// changing for the debounce interval. It blocks until ctx is cancelled or the
+// underlying watcher fails.
+func Watch(ctx context.Context, dir string, debounce time.Duration, changed chan<- string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := addTree(watcher, dir); err != nil {
+		return err
+	}
+
+	var (
+		mu     sync.Mutex
+		timers = map[string]*time.Timer{}
+	)
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, t := range timers {
+			t.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					_ = addTree(watcher, event.Name)
+					continue
+				}
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			if filepath.Ext(event.Name) != ".go" {
+				continue
+			}
+
+			path := event.Name
+			mu.Lock()
+			if t, ok := timers[path]; ok {
+				t.Reset(debounce)
+			} else {
+				timers[path] = time.AfterFunc(debounce, func() {
+					mu.Lock()
+					delete(timers, path)
+					mu.Unlock()
+
+					select {
+					case changed <- path:
+					case <-ctx.Done():
+					}
+				})
+			}
+			mu.Unlock()
+		}
+	}
+}
+
+// GoFiles returns every Go file under dir that Watch would report.
+func GoFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && skipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) == ".go" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// addTree watches root and every directory below it.
+func addTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && skipDir(d.Name()) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+func skipDir(name string) bool {
+	return skipDirs[name] || strings.HasPrefix(name, ".")
+}
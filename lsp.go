@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/sst/opencode-sdk-go"
+	"github.com/sst/opencode-sdk-go/option"
+	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/lsp"
+	"github.com/thomasgormley/chisel/internal/runner"
+)
+
+// runLSP speaks the Language Server Protocol over stdin and stdout so editors
+// can list and run directives through code actions. Progress that would
+// normally be printed goes to stderr, which editors keep as the server log.
+func runLSP(ctx context.Context, args []string) error {
+	flags, err := parseFlags("chisel lsp", "[flags]", args)
+	if err != nil {
+		flags.flagSet.Usage()
+		return err
+	}
+	if flags.emitter != nil {
+		return errors.New("--output ndjson cannot be used with chisel lsp, which owns stdout")
+	}
+	flags.out = os.Stderr
+
+	client := opencode.NewClient(option.WithBaseURL(flags.BaseURL()))
+	r, err := newRunner(client, flags)
+	if err != nil {
+		return err
+	}
+
+	parser := directive.NewParser(directive.WithFailed(flags.retryFailed))
+	// The runner holds the read-only state and language server errors of the
+	// directive in progress, so jobs for different files take turns.
+	var turn sync.Mutex
+	run := func(ctx context.Context, job lsp.Job) ([]runner.Result, error) {
+		turn.Lock()
+		defer turn.Unlock()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return runDirectives(ctx, client, r, parser, flags, job.File, job.IDs, job.Report)
+	}
+	preview := func(file string, d directive.AIDirective) (string, error) {
+		return r.Prompt(ctx, file, d)
+	}
+	server := lsp.NewServer(parser, run, preview)
+	return server.Serve(ctx, os.Stdin, os.Stdout)
+}
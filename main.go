@@ -2,138 +2,215 @@ package main
 
 import (
 	"context"
-	_ "embed"
+	"embed"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"path"
+	"slices"
+	"strings"
 	"syscall"
 
 	"github.com/sst/opencode-sdk-go"
 	"github.com/sst/opencode-sdk-go/option"
 	"github.com/thomasgormley/chisel/internal/agent"
+	"github.com/thomasgormley/chisel/internal/config"
 	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/output"
 	"github.com/thomasgormley/chisel/internal/print"
+	"github.com/thomasgormley/chisel/internal/prompt"
+	"github.com/thomasgormley/chisel/internal/runner"
+	"github.com/thomasgormley/chisel/internal/sessions"
+	"github.com/thomasgormley/chisel/internal/validate"
 )
 
 //go:embed prompts/system.md
 var systemPrompt []byte
 
+//go:embed prompts/read-only.md
+var readOnlyPrompt []byte
+
 //go:embed prompts/directive-context.md
 var directivePromptFile []byte
 
+//go:embed prompts/repair.md
+var repairPromptFile []byte
+
+//go:embed prompts/verbs/*.md
+var verbPromptFiles embed.FS
+
 func main() {
 	ctx := context.Background()
-	if err := run(ctx, os.Args[1:]); err != nil {
+	args := os.Args[1:]
+	err := run(ctx, args)
+	if err != nil {
 		print.Errorf(os.Stderr, "error running CLI: %s\n", err)
+		events.Emit(output.TypeError, output.Error{Message: err.Error()})
+	}
+	if holdsTerminal(args) {
+		// Prompt on stderr so machine-readable stdout stays clean.
+		print.Info(os.Stderr, "Press Enter to exit...\n")
+		var input string
+		fmt.Scanln(&input)
+	}
+	if err != nil {
+		os.Exit(1)
 	}
+}
+
+// events is the NDJSON emitter of the running subcommand, if it asked for one,
+// so main can end the stream with any error it returns.
+var events *output.Emitter
 
-	print.Info(os.Stdout, "Press Enter to exit...\n")
-	var input string
-	fmt.Scanln(&input)
+// holdsTerminal reports whether chisel waits for Enter before exiting so a
+// terminal it was launched in stays open. Only interactive runs wait: the lsp
+// and serve subcommands own their stdin, NDJSON output is read by a program,
+// and editors and scripts pipe stdin.
+func holdsTerminal(args []string) bool {
+	if events != nil || len(args) > 0 && (args[0] == "lsp" || args[0] == "serve") {
+		return false
+	}
+	return isTerminal(os.Stdin) && isTerminal(os.Stderr)
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
 func run(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "watch":
+			return runWatch(ctx, args[1:])
+		case "replay":
+			return runReplay(ctx, args[1:])
+		case "attach":
+			return runAttach(ctx, args[1:])
+		case "sessions":
+			return runSessions(ctx, args[1:])
+		case "lsp":
+			return runLSP(ctx, args[1:])
+		case "serve":
+			return runServe(ctx, args[1:])
+		case "config":
+			return runConfig(ctx, args[1:])
+		case "do":
+			return runDo(ctx, args[1:])
+		}
+	}
+	return runFile(ctx, args)
+}
+
+// runFile processes every directive in a single file and exits.
+func runFile(ctx context.Context, args []string) error {
 	mainCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	ctx, stop := signal.NotifyContext(mainCtx, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	flags, ok, err := parseFlags(args)
-	if err != nil || !ok {
+	var (
+		filter directive.Filter
+		pick   bool
+	)
+	flags, err := parseFlags("chisel", "[flags] <file>", args, func(fs *flag.FlagSet) {
+		fs.Func("only", "process only directives matching `selector`: a function glob, line (file.go:120) or ID; comma-separated or repeated", appendList(&filter.Only))
+		fs.Func("skip", "skip directives matching `selector`, as for --only", appendList(&filter.Skip))
+		fs.BoolVar(&pick, "pick", false, "choose directives from a list; needs a terminal")
+	})
+	if err != nil || flags.flagSet.NArg() < 1 {
 		flags.flagSet.Usage()
 		return err
 	}
+	if err := filter.Check(); err != nil {
+		return err
+	}
 
 	sourceFile := flags.flagSet.Arg(0)
+	if flags.config.Ignored(sourceFile) {
+		print.Warning(flags.out, sourceFile, "is ignored by configuration.")
+		return nil
+	}
 	file, err := os.ReadFile(sourceFile)
 	if err != nil {
 		return err
 	}
 
-	parser := directive.NewParser()
+	parser := directive.NewParser(directive.WithFailed(flags.retryFailed))
 	directives, err := parser.Parse(file)
 	if err != nil {
 		return err
 	}
 
 	if len(directives) == 0 {
-		print.Warning(os.Stdout, "No @ai directives found. To apply a directive, add a comment like // @ai <instruction> in your code.")
+		print.Warning(flags.out, "No @ai directives found. To apply a directive, add a comment like // @ai <instruction> in your code.")
 		return nil
 	}
+	if directives = filter.Apply(sourceFile, directives); len(directives) == 0 {
+		print.Warning(flags.out, "No @ai directives match --only and --skip.")
+		return nil
+	}
+	if pick {
+		if directives, err = pickDirectives(os.Stdin, os.Stderr, sourceFile, directives); err != nil {
+			return err
+		}
+		if len(directives) == 0 {
+			print.Warning(flags.out, "No directives picked.")
+			return nil
+		}
+	}
 
 	client := opencode.NewClient(option.WithBaseURL(flags.BaseURL()))
-	session, err := client.Session.New(ctx, opencode.SessionNewParams{
-		Directory: opencode.String(flags.dir),
-	})
+	r, err := newRunner(client, flags)
 	if err != nil {
 		return err
 	}
 
-	_, err = client.Session.Get(ctx, session.ID, opencode.SessionGetParams{})
+	session, err := startSession(ctx, client, flags, sessions.Title(sourceFile, len(directives)))
+	if err != nil {
+		return err
+	}
+
+	if flags.session != "" {
+		directives, err = skipCompleted(ctx, flags.out, r, session.ID, directives)
+		if err != nil {
+			return err
+		}
+		if len(directives) == 0 {
+			print.Success(flags.out, "All directives in", sourceFile, "were already completed in session", session.ID)
+			return nil
+		}
+	}
+
+	listenOpts, closeRecording, err := listenOptions(client, flags, r)
 	if err != nil {
 		return err
 	}
+	defer closeRecording()
 
 	listenerErrCh := make(chan error, 1)
 	go func() {
-		listenerErrCh <- agent.ListenForEvents(ctx, client, session.ID)
+		listenerErrCh <- agent.ListenForEvents(ctx, client, session.ID, listenOpts...)
 	}()
 
 	directiveErrCh := make(chan error, 1)
 	go func() {
-		for _, d := range directives {
-			modelParams := opencode.F(opencode.SessionPromptParamsModel{
-				ModelID:    opencode.String(flags.model),
-				ProviderID: opencode.String(flags.provider),
-			})
-			print.Info(os.Stdout, "Processing directive in function:", d.Function)
-			print.Info(os.Stdout, "->", modelParams.Value.ProviderID.String(), "/", modelParams.Value.ModelID.String())
-			promptText, err := d.Prompt()
-			if err != nil {
-				directiveErrCh <- err
-				return
-			}
-
-			if os.Getenv("SKIP_PROCESS") == "1" {
-				print.Warning(os.Stdout, "Skipping processing")
-				continue
-			}
-			rsp, err := client.Session.Prompt(
-				ctx,
-				session.ID,
-				opencode.SessionPromptParams{
-					Directory: opencode.String(flags.dir),
-					System:    opencode.String(string(systemPrompt)),
-					Model:     modelParams,
-					Parts: opencode.F(
-						[]opencode.SessionPromptParamsPartUnion{
-							opencode.TextPartInputParam{
-								Type: opencode.F(opencode.TextPartInputType("text")),
-								Text: opencode.String(fmt.Sprintf(string(directivePromptFile),
-									d.Function,
-									sourceFile,
-									d.StartLine,
-									d.EndLine,
-									promptText,
-									detectLanguage(sourceFile),
-									d.Source,
-								)),
-							},
-						}),
-				},
-			)
-			if err != nil {
-				var json []byte
-				rsp.UnmarshalJSON(json)
-				print.Error(os.Stdout, "err prompting: ", string(json))
-				directiveErrCh <- fmt.Errorf("prompting: %w", err)
-				return
-			}
+		results, err := r.Run(ctx, session.ID, sourceFile, directives)
+		runner.PrintSummary(flags.out, results)
+		runner.EmitSummary(flags.emitter, results)
+		if err != nil {
+			directiveErrCh <- err
+			return
+		}
+		if failed := runner.Failed(results); failed > 0 {
+			directiveErrCh <- fmt.Errorf("%d of %d directives failed", failed, len(results))
+			return
 		}
-		print.Success(os.Stdout, "\nAll directives processed. Check filesystem for changes.")
+		print.Success(flags.out, "\nAll directives processed. Check filesystem for changes.")
 		directiveErrCh <- nil
 	}()
 
@@ -142,25 +219,412 @@ func run(ctx context.Context, args []string) error {
 	case err := <-directiveErrCh:
 		cancel()
 		<-listenerErrCh
+		if err == nil && !flags.keepSession && sessions.IsChisel(*session) {
+			cleanupSession(context.WithoutCancel(mainCtx), client, flags, session.ID)
+		}
 		return err
 	case err := <-listenerErrCh:
 		cancel()
 		return fmt.Errorf("event stream error: %w", err)
 	case <-ctx.Done():
-		print.Warning(os.Stdout, print.Wrap("Shutting down, aborting client session..."))
-		abortRsp, err := client.Session.Abort(mainCtx, session.ID, opencode.SessionAbortParams{})
-		if err != nil {
-			print.Warning(os.Stdout, print.Wrap("Failed to abort client session:", err.Error()))
-		} else if abortRsp == nil || !*abortRsp {
-			print.Warning(os.Stdout, print.Wrap("Client session abort did not confirm success."))
-		} else {
-			print.Info(os.Stdout, print.Wrap("Client session aborted successfully."))
-		}
+		abortSession(mainCtx, flags.out, client, session.ID)
 		<-listenerErrCh
 		return ctx.Err()
 	}
 }
 
+// startSession creates a session titled title in the configured directory, or
+// resumes the one named by --session.
+func startSession(ctx context.Context, client *opencode.Client, flags cliFlags, title string) (*opencode.Session, error) {
+	if flags.session != "" {
+		session, err := client.Session.Get(ctx, flags.session, opencode.SessionGetParams{
+			Directory: opencode.String(flags.dir),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("resuming session %s: %w", flags.session, err)
+		}
+		print.Info(flags.out, "Resuming session", session.ID)
+		return session, nil
+	}
+
+	session, err := client.Session.New(ctx, opencode.SessionNewParams{
+		Directory: opencode.String(flags.dir),
+		Title:     opencode.String(title),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = client.Session.Get(ctx, session.ID, opencode.SessionGetParams{})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// cleanupSession deletes a session whose directives all succeeded.
+func cleanupSession(ctx context.Context, client *opencode.Client, flags cliFlags, sessionID string) {
+	if err := sessions.Delete(ctx, client, flags.dir, sessionID); err != nil {
+		print.Warning(flags.out, "Failed to delete session:", err.Error())
+		return
+	}
+	print.Info(flags.out, "Deleted session", sessionID, "(use --keep-session to retain it)")
+}
+
+// skipCompleted drops directives that were already answered in sessionID.
+func skipCompleted(ctx context.Context, w io.Writer, r *runner.Runner, sessionID string, directives []directive.AIDirective) ([]directive.AIDirective, error) {
+	completed, err := r.Completed(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var remaining []directive.AIDirective
+	for _, d := range directives {
+		if completed[d.ID()] {
+			print.Info(w, "Skipping directive in", d.Function, "(already completed)")
+			continue
+		}
+		remaining = append(remaining, d)
+	}
+	return remaining, nil
+}
+
+// runDirectives runs the directives in file with the given IDs, or all of them
+// when ids is empty, in a session of their own. report, if set, is called
+// before each directive starts.
+func runDirectives(ctx context.Context, client *opencode.Client, r *runner.Runner, parser *directive.Parser, flags cliFlags, file string, ids []string, report func(done, total int, d directive.AIDirective)) ([]runner.Result, error) {
+	if flags.config.Ignored(file) {
+		return nil, fmt.Errorf("%s is ignored by configuration", file)
+	}
+	directives, err := parseSelected(parser, file, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(directives) == 0 {
+		return nil, nil
+	}
+	refresh := func() ([]directive.AIDirective, error) { return parseSelected(parser, file, ids) }
+	return runSession(ctx, client, r, flags, file, directives, refresh, report)
+}
+
+// runSession runs directives from file one at a time in a session of their
+// own. refresh, if set, re-parses the file so later directives pick up their
+// position after earlier edits.
+func runSession(ctx context.Context, client *opencode.Client, r *runner.Runner, flags cliFlags, file string, directives []directive.AIDirective, refresh func() ([]directive.AIDirective, error), report func(done, total int, d directive.AIDirective)) ([]runner.Result, error) {
+	session, err := startSession(ctx, client, flags, sessions.Title(file, len(directives)))
+	if err != nil {
+		return nil, err
+	}
+
+	listenOpts, closeRecording, err := listenOptions(client, flags, r)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRecording()
+
+	listenCtx, stopListening := context.WithCancel(ctx)
+	listenerErrCh := make(chan error, 1)
+	go func() {
+		listenerErrCh <- agent.ListenForEvents(listenCtx, client, session.ID, listenOpts...)
+	}()
+
+	results, runErr := runInSession(ctx, r, flags, session.ID, file, directives, refresh, report)
+
+	stopListening()
+	<-listenerErrCh
+
+	if ctx.Err() != nil {
+		abortSession(context.WithoutCancel(ctx), flags.out, client, session.ID)
+		return results, ctx.Err()
+	}
+	if runErr == nil && runner.Failed(results) == 0 && !flags.keepSession && sessions.IsChisel(*session) {
+		cleanupSession(ctx, client, flags, session.ID)
+	}
+	return results, runErr
+}
+
+// runInSession runs directives from file one at a time in sessionID, whose
+// events are already being handled, and prints a summary of the results.
+func runInSession(ctx context.Context, r *runner.Runner, flags cliFlags, sessionID, file string, directives []directive.AIDirective, refresh func() ([]directive.AIDirective, error), report func(done, total int, d directive.AIDirective)) ([]runner.Result, error) {
+	var (
+		results []runner.Result
+		runErr  error
+	)
+	for i, d := range directives {
+		if i > 0 && refresh != nil {
+			// Earlier edits shift offsets, so pick up the directive's current position.
+			if current, err := refresh(); err == nil {
+				if found, ok := directive.Locate(current, d); ok {
+					d = found
+				}
+			}
+		}
+		if report != nil {
+			report(i, len(directives), d)
+		}
+
+		var res []runner.Result
+		res, runErr = r.Run(ctx, sessionID, file, []directive.AIDirective{d})
+		results = append(results, res...)
+		if runErr != nil {
+			break
+		}
+	}
+	runner.PrintSummary(flags.out, results)
+
+	return results, runErr
+}
+
+// parseSelected returns the directives in file with the given IDs, or all of
+// them when ids is empty.
+func parseSelected(parser *directive.Parser, file string, ids []string) ([]directive.AIDirective, error) {
+	code, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	directives, err := parser.Parse(code)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+
+	var selected []directive.AIDirective
+	for _, d := range directives {
+		if len(ids) == 0 || slices.Contains(ids, d.ID()) {
+			selected = append(selected, d)
+		}
+	}
+	return selected, nil
+}
+
+// abortSession asks the server to stop any work in progress for sessionID.
+func abortSession(ctx context.Context, w io.Writer, client *opencode.Client, sessionID string) {
+	print.Warning(w, print.Wrap("Shutting down, aborting client session..."))
+	abortRsp, err := client.Session.Abort(ctx, sessionID, opencode.SessionAbortParams{})
+	if err != nil {
+		print.Warning(w, print.Wrap("Failed to abort client session:", err.Error()))
+	} else if abortRsp == nil || !*abortRsp {
+		print.Warning(w, print.Wrap("Client session abort did not confirm success."))
+	} else {
+		print.Info(w, print.Wrap("Client session aborted successfully."))
+	}
+}
+
+// listenOptions configures the event listener from flags. The returned
+// function closes any recording file and is safe to call when none was opened.
+func listenOptions(client *opencode.Client, flags cliFlags, r *runner.Runner) ([]agent.ListenOption, func() error, error) {
+	var opts []agent.ListenOption
+	if flags.out != nil {
+		handlerOpts := []agent.HandlerOption{
+			agent.WithPermissionResponder(permissionResponder(client, flags.config, r)),
+			agent.WithEmitter(flags.emitter),
+		}
+		if r != nil {
+			handlerOpts = append(handlerOpts, agent.WithDiagnostics(lspErrors(r)))
+		}
+		opts = append(opts, agent.WithHandler(agent.NewHandler(flags.out, handlerOpts...)))
+	}
+	if flags.record == "" {
+		return opts, func() error { return nil }, nil
+	}
+
+	f, err := os.Create(flags.record)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating recording: %w", err)
+	}
+	return append(opts, agent.WithRecorder(agent.NewRecorder(f))), f.Close, nil
+}
+
+// lspErrors passes the errors among the diagnostics reported after each edit
+// on to r.
+func lspErrors(r *runner.Runner) agent.DiagnosticsFunc {
+	return func(path string, diagnostics []agent.Diagnostic) {
+		var errs []runner.LSPError
+		for _, d := range diagnostics {
+			if d.Severity == agent.SeverityError {
+				errs = append(errs, runner.LSPError{Line: d.Line, Column: d.Column, Message: d.Message})
+			}
+		}
+		r.SetLSPErrors(path, errs)
+	}
+}
+
+// applyConfig loads the configuration for the target file, or --dir when no
+// file was given, and fills in every flag that was not set explicitly.
+func applyConfig(flags *cliFlags) error {
+	start := flags.dir
+	if flags.flagSet.NArg() > 0 {
+		start = flags.flagSet.Arg(0)
+	}
+	if start == "" {
+		start = "."
+	}
+	cfg, err := config.Load(start)
+	if err != nil {
+		return err
+	}
+
+	set := map[string]bool{}
+	flags.flagSet.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	layer(set["dir"], &flags.dir, &cfg.Dir)
+	layer(set["host"], &flags.host, &cfg.Server.Host)
+	layer(set["port"], &flags.port, &cfg.Server.Port)
+	layer(set["model"], &flags.model, &cfg.Model.Name)
+	layer(set["provider"], &flags.provider, &cfg.Model.Provider)
+	layer(set["validate"], &flags.checks, &cfg.Validate.Checks)
+	layer(set["repair-rounds"], &flags.repairRounds, &cfg.Validate.RepairRounds)
+	layer(set["context-budget"], &flags.contextBudget, &cfg.Context.Budget)
+	layer(set["context-types"], &flags.contextTypes, &cfg.Context.Types)
+	layer(set["context-callers"], &flags.contextCallers, &cfg.Context.Callers)
+	layer(set["fix-imports"], &flags.fixImports, &cfg.Validate.FixImports)
+	layer(set["test"], &flags.test, &cfg.Validate.Test)
+	layer(set["test-related"], &flags.testRelated, &cfg.Validate.TestRelated)
+	if len(set) > 0 {
+		cfg.Sources = append(cfg.Sources, "command-line flags")
+	}
+	flags.config = cfg
+	return nil
+}
+
+// permissionResponder answers permissions as configured, asking with a dialog
+// for any type set to "ask". Requests that would let a read-only directive
+// change files are rejected outright.
+func permissionResponder(client *opencode.Client, cfg config.Config, r *runner.Runner) agent.PermissionResponder {
+	return agent.PolicyResponder(client, func(typ string) opencode.SessionPermissionRespondParamsResponse {
+		if r != nil && r.Denies(typ) {
+			return opencode.SessionPermissionRespondParamsResponseReject
+		}
+		switch cfg.Permission(typ) {
+		case config.PermissionAllow:
+			return opencode.SessionPermissionRespondParamsResponseOnce
+		case config.PermissionAlways:
+			return opencode.SessionPermissionRespondParamsResponseAlways
+		case config.PermissionDeny:
+			return opencode.SessionPermissionRespondParamsResponseReject
+		}
+		return ""
+	}, agent.DialogResponder(client))
+}
+
+// appendList returns a flag.Func handler that appends comma-separated values
+// to list, so the flag can be repeated.
+func appendList(list *[]string) func(string) error {
+	return func(s string) error {
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				*list = append(*list, v)
+			}
+		}
+		return nil
+	}
+}
+
+// layer copies an explicitly set flag into the config, or the configured value
+// into an unset flag, so both end up holding the effective value.
+func layer[T any](flagSet bool, flagValue, configValue *T) {
+	if flagSet {
+		*configValue = *flagValue
+	} else {
+		*flagValue = *configValue
+	}
+}
+
+// newRunner builds a directive runner from the parsed flags.
+func newRunner(client *opencode.Client, flags cliFlags) (*runner.Runner, error) {
+	checks, err := validate.Lookup(flags.checks)
+	if err != nil {
+		return nil, err
+	}
+	prompts, err := loadPrompts(flags.config.Prompts)
+	if err != nil {
+		return nil, err
+	}
+	return runner.New(client, runner.Options{
+		Dir:            flags.dir,
+		Model:          flags.model,
+		Provider:       flags.provider,
+		Prompts:        prompts,
+		Checks:         checks,
+		RepairRounds:   flags.repairRounds,
+		FixImports:     flags.fixImports,
+		Leftover:       runner.LeftoverMode(flags.leftover),
+		RunID:          runner.NewRunID(),
+		MarkFailures:   flags.markFailures,
+		RetryFailed:    flags.retryFailed,
+		Test:           flags.test,
+		TestRelated:    flags.testRelated,
+		ContextBudget:  flags.contextBudget,
+		ContextTypes:   flags.contextTypes,
+		ContextCallers: flags.contextCallers,
+		ReadOnly:       flags.readOnly,
+		Report:         flags.report,
+		Out:            flags.out,
+		Emitter:        flags.emitter,
+	}), nil
+}
+
+// loadPrompts parses the prompt templates, using the configured overrides in
+// place of the embedded ones.
+func loadPrompts(overrides config.Prompts) (*prompt.Templates, error) {
+	system, err := loadPrompt(overrides.System, systemPrompt)
+	if err != nil {
+		return nil, err
+	}
+	readOnly, err := loadPrompt(overrides.ReadOnly, readOnlyPrompt)
+	if err != nil {
+		return nil, err
+	}
+	directiveText, err := loadPrompt(overrides.Context, directivePromptFile)
+	if err != nil {
+		return nil, err
+	}
+	repair, err := loadPrompt(overrides.Repair, repairPromptFile)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := verbPromptFiles.ReadDir("prompts/verbs")
+	if err != nil {
+		return nil, err
+	}
+	verbs := map[string]string{}
+	for _, e := range entries {
+		builtin, err := verbPromptFiles.ReadFile(path.Join("prompts/verbs", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		verb := strings.TrimSuffix(e.Name(), ".md")
+		if verbs[verb], err = loadPrompt(overrides.Verbs[verb], builtin); err != nil {
+			return nil, err
+		}
+	}
+	for verb := range overrides.Verbs {
+		if _, ok := verbs[verb]; !ok {
+			return nil, fmt.Errorf("prompt override for unknown directive verb %q", verb)
+		}
+	}
+
+	return prompt.Parse(prompt.Sources{
+		System:    system,
+		ReadOnly:  readOnly,
+		Directive: directiveText,
+		Repair:    repair,
+		Verbs:     verbs,
+	})
+}
+
+// loadPrompt reads the prompt override at path, or returns builtin when no
+// override is configured.
+func loadPrompt(path string, builtin []byte) (string, error) {
+	if path == "" {
+		return string(builtin), nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading prompt override: %w", err)
+	}
+	return string(b), nil
+}
+
 type cliFlags struct {
 	host     string
 	port     string
@@ -168,6 +632,33 @@ type cliFlags struct {
 	provider string
 	dir      string
 
+	checks         []string
+	repairRounds   int
+	contextBudget  int
+	contextTypes   bool
+	contextCallers int
+	fixImports     bool
+	leftover       string
+	markFailures   bool
+	retryFailed    bool
+	test           bool
+	testRelated    bool
+	record         string
+	session        string
+	keepSession    bool
+	readOnly       bool
+	report         string
+	output         string
+
+	// config is the effective configuration: files and environment, with
+	// any explicitly set flags applied on top.
+	config config.Config
+
+	// out receives human-readable progress and emitter machine-readable
+	// events; which one is live depends on --output.
+	out     io.Writer
+	emitter *output.Emitter
+
 	flagSet *flag.FlagSet
 }
 
@@ -179,19 +670,60 @@ func (c cliFlags) BaseURL() string {
 	return url
 }
 
-func parseFlags(args []string) (cliFlags, bool, error) {
-	flagSet := flag.NewFlagSet("chisel", flag.ExitOnError)
+// serverFlags registers the flags that locate the opencode server, defaulting
+// to the server configured for the current directory.
+func serverFlags(flagSet *flag.FlagSet, flags *cliFlags) {
+	if cfg, err := config.Load("."); err == nil {
+		flags.host, flags.port = cfg.Server.Host, cfg.Server.Port
+	} else {
+		defaults := config.Default()
+		flags.host, flags.port = defaults.Server.Host, defaults.Server.Port
+	}
+	flagSet.StringVar(&flags.host, "host", flags.host, "opencode server host (including protocol)")
+	flagSet.StringVar(&flags.port, "port", flags.port, "opencode server port")
+}
+
+// parseFlags parses the flags shared by every command, plus any registered by
+// extra, and layers them over the configuration for the target. Positional
+// arguments are left on the returned flag set for the caller to check.
+func parseFlags(name, usage string, args []string, extra ...func(*flag.FlagSet)) (cliFlags, error) {
+	flags, err := loadFlags(name, usage, args, extra...)
+	if err != nil {
+		return flags, err
+	}
+	if flags.dir == "" {
+		return flags, fmt.Errorf("--dir flag is required")
+	}
+	return flags, nil
+}
+
+// loadFlags is parseFlags without requiring a directory.
+func loadFlags(name, usage string, args []string, extra ...func(*flag.FlagSet)) (cliFlags, error) {
+	flagSet := flag.NewFlagSet(name, flag.ExitOnError)
 	flagSet.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: chisel [flags] <file>\n")
+		fmt.Fprintf(os.Stderr, "usage: %s %s\n", name, usage)
 		flagSet.PrintDefaults()
 	}
 
+	// Flags show the built-in defaults; configured values are applied after
+	// parsing to any flag that was not set explicitly.
+	defaults := config.Default()
 	flags := cliFlags{
-		host:     "http://localhost",
-		port:     "3366",
-		model:    "big-pickle",
-		provider: "opencode",
+		host:     defaults.Server.Host,
+		port:     defaults.Server.Port,
+		model:    defaults.Model.Name,
+		provider: defaults.Model.Provider,
 
+		checks:         defaults.Validate.Checks,
+		repairRounds:   defaults.Validate.RepairRounds,
+		contextBudget:  defaults.Context.Budget,
+		contextTypes:   defaults.Context.Types,
+		contextCallers: defaults.Context.Callers,
+		fixImports:     defaults.Validate.FixImports,
+		leftover:       string(runner.LeftoverStrip),
+		output:         string(output.FormatText),
+
+		out:     os.Stdout,
 		flagSet: flagSet,
 	}
 	flagSet.StringVar(&flags.dir, "dir", "", "directory to process")
@@ -199,26 +731,51 @@ func parseFlags(args []string) (cliFlags, bool, error) {
 	flagSet.StringVar(&flags.port, "port", flags.port, "opencode server port")
 	flagSet.StringVar(&flags.model, "model", flags.model, "model to use")
 	flagSet.StringVar(&flags.provider, "provider", flags.provider, "provider to use")
+	flagSet.Func("validate", "comma-separated checks to run after each directive, empty disables (default \"gofmt,build,vet\")", func(s string) error {
+		flags.checks = strings.Split(s, ",")
+		return nil
+	})
+	flagSet.IntVar(&flags.repairRounds, "repair-rounds", flags.repairRounds, "follow-up prompts allowed to fix failed checks")
+	flagSet.IntVar(&flags.contextBudget, "context-budget", flags.contextBudget, "bytes of same-file declarations the target refers to to include as context; 0 disables")
+	flagSet.BoolVar(&flags.contextTypes, "context-types", flags.contextTypes, "also include declarations from other files and packages, using the package's type information")
+	flagSet.IntVar(&flags.contextCallers, "context-callers", flags.contextCallers, "bytes of callers and tests of the target to include as context; 0, the default, disables, @ai(callers=...) overrides per directive")
+	flagSet.BoolVar(&flags.fixImports, "fix-imports", flags.fixImports, "resolve TODO import markers and missing or unused imports after each directive")
+	flagSet.StringVar(&flags.leftover, "leftover", flags.leftover, "how to handle directive comments the agent left behind: strip or mark (as @ai-done); empty disables")
+	flagSet.BoolVar(&flags.markFailures, "mark-failures", false, "rewrite failed directives as @ai-failed(<reason>, run=<id>)")
+	flagSet.BoolVar(&flags.retryFailed, "retry-failed", false, "also process directives marked @ai-failed by an earlier run")
+	flagSet.BoolVar(&flags.test, "test", false, "run the package's tests after each directive")
+	flagSet.BoolVar(&flags.testRelated, "test-related", false, "with --test, only run tests that reference the target function")
+	flagSet.StringVar(&flags.session, "session", "", "continue an existing session instead of creating one")
+	flagSet.BoolVar(&flags.keepSession, "keep-session", false, "keep the session after all directives succeed instead of deleting it")
+	flagSet.StringVar(&flags.record, "record", "", "write every server event to `file` as JSON lines for chisel replay")
+	flagSet.BoolVar(&flags.readOnly, "read-only", false, "ask for the agent's answer instead of an edit and leave files untouched")
+	flagSet.StringVar(&flags.report, "report", "", "append answers to read-only directives to `file` as Markdown instead of printing them")
+	flagSet.StringVar(&flags.output, "output", flags.output, "progress format: text, or ndjson for one JSON event per line on stdout")
+	for _, register := range extra {
+		register(flagSet)
+	}
 
 	flagSet.Parse(args)
 
-	if flags.dir == "" {
-		return flags, false, fmt.Errorf("--dir flag is required")
+	if err := applyConfig(&flags); err != nil {
+		return flags, err
 	}
 
-	if flagSet.NArg() < 1 {
-		return cliFlags{}, false, nil
+	switch runner.LeftoverMode(flags.leftover) {
+	case "", runner.LeftoverStrip, runner.LeftoverMark:
+	default:
+		return flags, fmt.Errorf("--leftover must be strip or mark, got %q", flags.leftover)
 	}
 
-	return flags, true, nil
-}
-
-func detectLanguage(filePath string) string {
-	ext := filepath.Ext(filePath)
-	switch ext {
-	case ".go":
-		return "go"
-	default:
-		return ""
+	format, err := output.ParseFormat(flags.output)
+	if err != nil {
+		return flags, fmt.Errorf("--output: %w", err)
+	}
+	if format == output.FormatNDJSON {
+		flags.emitter = output.NewEmitter(os.Stdout)
+		flags.out = io.Discard
+		events = flags.emitter
 	}
+
+	return flags, nil
 }
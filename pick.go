@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/print"
+)
+
+// pickDirectives lists directives on out and reads the ones to run from in,
+// which must be a terminal.
+func pickDirectives(in *os.File, out io.Writer, file string, directives []directive.AIDirective) ([]directive.AIDirective, error) {
+	if !isTerminal(in) {
+		return nil, errors.New("--pick needs an interactive terminal")
+	}
+
+	print.Info(out, "Directives in", file+":")
+	for i, d := range directives {
+		instruction, _ := d.Prompt()
+		instruction, _, _ = strings.Cut(instruction, "\n")
+		marker := "@ai"
+		if d.Verb != directive.VerbEdit {
+			marker += ":" + string(d.Verb)
+		}
+		print.Infof(out, "  %2d) %s %s %s\n", i+1,
+			print.ColorNote(fmt.Sprintf("%s:%d", d.Function, d.StartLine)),
+			print.ColorSubtle(d.ID()),
+			marker+" "+instruction)
+	}
+
+	reader := bufio.NewReader(in)
+	for {
+		print.Infof(out, "Run which? (e.g. 1,3-4; empty for all, q to quit): ")
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "q" {
+			return nil, nil
+		}
+		picked, err := parseSelection(line, len(directives))
+		if err != nil {
+			print.Warning(out, err.Error())
+			continue
+		}
+
+		var selected []directive.AIDirective
+		for _, i := range picked {
+			selected = append(selected, directives[i])
+		}
+		return selected, nil
+	}
+}
+
+// parseSelection parses a list of 1-based numbers and ranges such as "1,3-4"
+// into sorted, distinct 0-based indexes below n. Empty selects everything.
+func parseSelection(s string, n int) ([]int, error) {
+	chosen := make([]bool, n)
+	if s == "" {
+		for i := range chosen {
+			chosen[i] = true
+		}
+	}
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		lo, hi, isRange := strings.Cut(field, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number or range", field)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil {
+				return nil, fmt.Errorf("%q is not a number or range", field)
+			}
+		}
+		if first < 1 || last > n || first > last {
+			return nil, fmt.Errorf("%q is outside 1-%d", field, n)
+		}
+		for i := first; i <= last; i++ {
+			chosen[i-1] = true
+		}
+	}
+
+	var picked []int
+	for i, ok := range chosen {
+		if ok {
+			picked = append(picked, i)
+		}
+	}
+	return picked, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/thomasgormley/chisel/internal/agent"
+)
+
+// runReplay renders a recording made with --record through the same event
+// handlers used for a live session.
+func runReplay(ctx context.Context, args []string) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	flagSet := flag.NewFlagSet("chisel replay", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: chisel replay [flags] <events.jsonl>\n")
+		flagSet.PrintDefaults()
+	}
+	speed := flagSet.Float64("speed", 1, "playback speed multiplier; 0 replays without delays")
+	flagSet.Parse(args)
+
+	if flagSet.NArg() < 1 {
+		flagSet.Usage()
+		return nil
+	}
+
+	f, err := os.Open(flagSet.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	events, err := agent.ReadRecording(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", flagSet.Arg(0), err)
+	}
+
+	return agent.Replay(ctx, events, agent.NewHandler(os.Stdout), *speed)
+}
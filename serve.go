@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/sst/opencode-sdk-go"
+	"github.com/sst/opencode-sdk-go/option"
+	"github.com/thomasgormley/chisel/internal/agent"
+	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/output"
+	"github.com/thomasgormley/chisel/internal/print"
+	"github.com/thomasgormley/chisel/internal/runner"
+	"github.com/thomasgormley/chisel/internal/serve"
+	"github.com/thomasgormley/chisel/internal/sessions"
+)
+
+// runServe runs a long-lived HTTP daemon so editors and scripts can share one
+// chisel. Jobs run one at a time in a shared session, and each job's progress
+// is available as server-sent events in the same shape as --output ndjson.
+func runServe(ctx context.Context, args []string) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var addr string
+	flags, err := parseFlags("chisel serve", "[flags]", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&addr, "listen", "127.0.0.1:3377", "`address` to serve the HTTP API on")
+	})
+	if err != nil {
+		flags.flagSet.Usage()
+		return err
+	}
+	if flags.emitter != nil {
+		return errors.New("--output ndjson is not supported by chisel serve; stream job events instead")
+	}
+
+	// Jobs share one runner, session and event listener, so their events go
+	// through a single emitter pointed at whichever job is running.
+	events := &jobEvents{w: io.Discard}
+	jobFlags := flags
+	jobFlags.out = io.Discard
+	jobFlags.emitter = output.NewEmitter(events)
+
+	client := opencode.NewClient(option.WithBaseURL(flags.BaseURL()))
+	r, err := newRunner(client, jobFlags)
+	if err != nil {
+		return err
+	}
+	parser := directive.NewParser(directive.WithFailed(flags.retryFailed))
+
+	session, err := startSession(ctx, client, flags, sessions.ServeTitle(flags.dir))
+	if err != nil {
+		return err
+	}
+	listenOpts, closeRecording, err := listenOptions(client, jobFlags, r)
+	if err != nil {
+		return err
+	}
+	defer closeRecording()
+
+	listenCtx, stopListening := context.WithCancel(ctx)
+	defer stopListening()
+	listenerErrCh := make(chan error, 1)
+	go func() {
+		listenerErrCh <- agent.ListenForEvents(listenCtx, client, session.ID, listenOpts...)
+	}()
+
+	// A session runs one prompt at a time, so jobs take turns.
+	var (
+		turn   sync.Mutex
+		failed bool
+	)
+	run := func(ctx context.Context, req serve.Request, w io.Writer) ([]runner.Result, error) {
+		turn.Lock()
+		defer turn.Unlock()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		events.set(w)
+		defer events.set(io.Discard)
+
+		print.Info(flags.out, "▶ Running directives in", req.File)
+		results, err := runJob(ctx, r, parser, jobFlags, session.ID, req)
+		if ctx.Err() != nil {
+			abortSession(context.WithoutCancel(ctx), flags.out, client, session.ID)
+		}
+		switch {
+		case err != nil:
+			failed = true
+			print.Error(flags.out, "✗", req.File+":", err.Error())
+		case runner.Failed(results) > 0:
+			failed = true
+			print.Warning(flags.out, "⚠", req.File+":", fmt.Sprintf("%d of %d directives failed", runner.Failed(results), len(results)))
+		default:
+			print.Success(flags.out, "✓", req.File+":", fmt.Sprintf("%d directive(s) processed", len(results)))
+		}
+		return results, err
+	}
+
+	jobs := serve.NewManager(ctx, run)
+	server := &http.Server{Addr: addr, Handler: serve.NewHandler(jobs, flags.dir)}
+
+	serveErrCh := make(chan error, 1)
+	go func() {
+		serveErrCh <- server.ListenAndServe()
+	}()
+	print.Info(flags.out, "🌐 Serving chisel API on http://"+addr, "for", flags.dir+". Press Ctrl+C to stop.")
+
+	select {
+	case err := <-serveErrCh:
+		return err
+	case err := <-listenerErrCh:
+		_ = server.Shutdown(context.WithoutCancel(ctx))
+		jobs.Wait()
+		return fmt.Errorf("event stream error: %w", err)
+	case <-ctx.Done():
+	}
+
+	print.Warning(flags.out, print.Wrap("Shutting down, cancelling running jobs..."))
+	err = server.Shutdown(context.WithoutCancel(ctx))
+	jobs.Wait()
+	stopListening()
+	<-listenerErrCh
+
+	if !failed && !flags.keepSession && sessions.IsChisel(*session) {
+		cleanupSession(context.WithoutCancel(ctx), client, flags, session.ID)
+	}
+	return err
+}
+
+// runJob runs the directives a job selected in the serve session.
+func runJob(ctx context.Context, r *runner.Runner, parser *directive.Parser, flags cliFlags, sessionID string, req serve.Request) ([]runner.Result, error) {
+	if flags.config.Ignored(req.File) {
+		return nil, fmt.Errorf("%s is ignored by configuration", req.File)
+	}
+	directives, err := parseSelected(parser, req.File, req.Directives)
+	if err != nil || len(directives) == 0 {
+		return nil, err
+	}
+	refresh := func() ([]directive.AIDirective, error) { return parseSelected(parser, req.File, req.Directives) }
+	return runInSession(ctx, r, flags, sessionID, req.File, directives, refresh, nil)
+}
+
+// jobEvents forwards writes to the event log of the running job.
+type jobEvents struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (e *jobEvents) set(w io.Writer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w = w
+}
+
+func (e *jobEvents) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.w.Write(p)
+}
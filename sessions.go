@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sst/opencode-sdk-go"
+	"github.com/sst/opencode-sdk-go/option"
+	"github.com/thomasgormley/chisel/internal/print"
+	"github.com/thomasgormley/chisel/internal/sessions"
+)
+
+// runSessions lists the sessions chisel created and optionally prunes them.
+func runSessions(ctx context.Context, args []string) error {
+	flagSet := flag.NewFlagSet("chisel sessions", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: chisel sessions [flags]\n")
+		flagSet.PrintDefaults()
+	}
+	flags := cliFlags{
+		flagSet: flagSet,
+	}
+	serverFlags(flagSet, &flags)
+	flagSet.StringVar(&flags.dir, "dir", "", "only include sessions for this directory")
+	prune := flagSet.Bool("prune", false, "delete the listed sessions")
+	olderThan := flagSet.Duration("older-than", 0, "only include sessions not updated within this duration")
+	flagSet.Parse(args)
+
+	client := opencode.NewClient(option.WithBaseURL(flags.BaseURL()))
+	list, err := sessions.List(ctx, client, flags.dir)
+	if err != nil {
+		return err
+	}
+
+	var selected []opencode.Session
+	for _, s := range list {
+		if *olderThan > 0 && time.Since(sessions.Updated(s)) < *olderThan {
+			continue
+		}
+		selected = append(selected, s)
+	}
+
+	if len(selected) == 0 {
+		print.Info(os.Stdout, "No chisel sessions found.")
+		return nil
+	}
+
+	for _, s := range selected {
+		updated := sessions.Updated(s).Format(time.DateTime)
+		print.Info(os.Stdout, s.ID, print.ColorSubtle(updated), s.Title)
+	}
+
+	if !*prune {
+		return nil
+	}
+
+	deleted := 0
+	for _, s := range selected {
+		if err := sessions.Delete(ctx, client, s.Directory, s.ID); err != nil {
+			print.Warning(os.Stdout, err.Error())
+			continue
+		}
+		deleted++
+	}
+	print.Success(os.Stdout, print.WrapTop(fmt.Sprintf("Deleted %d of %d session(s).", deleted, len(selected))))
+	return nil
+}
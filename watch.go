@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sst/opencode-sdk-go"
+	"github.com/sst/opencode-sdk-go/option"
+	"github.com/thomasgormley/chisel/internal/agent"
+	"github.com/thomasgormley/chisel/internal/directive"
+	"github.com/thomasgormley/chisel/internal/print"
+	"github.com/thomasgormley/chisel/internal/runner"
+	"github.com/thomasgormley/chisel/internal/sessions"
+	"github.com/thomasgormley/chisel/internal/watch"
+)
+
+// runWatch keeps a session open and runs directives as they are added to Go
+// files under --dir. Directives present when watching starts are left alone.
+func runWatch(ctx context.Context, args []string) error {
+	mainCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ctx, stop := signal.NotifyContext(mainCtx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var debounce time.Duration
+	flags, err := parseFlags("chisel watch", "[flags]", args, func(fs *flag.FlagSet) {
+		fs.DurationVar(&debounce, "debounce", 500*time.Millisecond, "quiet period after a save before a file is parsed")
+	})
+	if err != nil {
+		flags.flagSet.Usage()
+		return err
+	}
+
+	parser := directive.NewParser(directive.WithFailed(flags.retryFailed))
+	tracker := watch.NewTracker()
+	if err := seedTracker(parser, tracker, flags.dir); err != nil {
+		return err
+	}
+
+	client := opencode.NewClient(option.WithBaseURL(flags.BaseURL()))
+	r, err := newRunner(client, flags)
+	if err != nil {
+		return err
+	}
+
+	session, err := startSession(ctx, client, flags, sessions.WatchTitle(flags.dir))
+	if err != nil {
+		return err
+	}
+
+	listenOpts, closeRecording, err := listenOptions(client, flags, r)
+	if err != nil {
+		return err
+	}
+	defer closeRecording()
+
+	listenerErrCh := make(chan error, 1)
+	go func() {
+		listenerErrCh <- agent.ListenForEvents(ctx, client, session.ID, listenOpts...)
+	}()
+
+	changed := make(chan string)
+	watchErrCh := make(chan error, 1)
+	go func() {
+		watchErrCh <- watch.Watch(ctx, flags.dir, debounce, changed)
+	}()
+
+	print.Info(flags.out, "👀 Watching", flags.dir, "for new @ai directives. Press Ctrl+C to stop.")
+
+	// The session is kept for inspection if any directive failed or was
+	// interrupted.
+	failed := false
+	cleanup := func() {
+		if !failed && !flags.keepSession && sessions.IsChisel(*session) {
+			cleanupSession(mainCtx, client, flags, session.ID)
+		}
+	}
+
+	for {
+		select {
+		case path := <-changed:
+			if flags.config.Ignored(path) {
+				continue
+			}
+			code, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			directives, err := parser.Parse(code)
+			if err != nil {
+				print.Warningf(flags.out, print.Wrap("Could not parse %s: %s"), path, err)
+				continue
+			}
+
+			fresh := tracker.Fresh(path, directives)
+			if len(fresh) == 0 {
+				continue
+			}
+
+			print.Info(flags.out, print.WrapTop(fmt.Sprintf("📝 %d new directive(s) in %s", len(fresh), path)))
+			results, err := r.Run(ctx, session.ID, path, fresh)
+			// Directives the agent left in its own edit, such as "@ai TODO:"
+			// notes, are not new work; running them could loop forever.
+			seedFile(parser, tracker, path)
+			runner.PrintSummary(flags.out, results)
+			runner.EmitSummary(flags.emitter, results)
+			if err != nil || runner.Failed(results) > 0 {
+				failed = true
+			}
+			if err != nil && !errors.Is(err, context.Canceled) {
+				print.Error(flags.out, "error processing", path+":", err.Error())
+			}
+
+		case err := <-watchErrCh:
+			if ctx.Err() != nil {
+				// Interrupted; let the ctx.Done case abort the session.
+				continue
+			}
+			cancel()
+			<-listenerErrCh
+			return fmt.Errorf("watching %s: %w", flags.dir, err)
+
+		case err := <-listenerErrCh:
+			if ctx.Err() != nil {
+				abortSession(mainCtx, flags.out, client, session.ID)
+				cleanup()
+				return nil
+			}
+			cancel()
+			return fmt.Errorf("event stream error: %w", err)
+
+		case <-ctx.Done():
+			abortSession(mainCtx, flags.out, client, session.ID)
+			<-listenerErrCh
+			cleanup()
+			return nil
+		}
+	}
+}
+
+// seedTracker records the directives already present under dir so watching
+// only picks up ones added afterwards.
+func seedTracker(parser *directive.Parser, tracker *watch.Tracker, dir string) error {
+	files, err := watch.GoFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		seedFile(parser, tracker, path)
+	}
+	return nil
+}
+
+// seedFile records the directives currently in path as already known.
+// Unreadable or unparsable files are left as they were.
+func seedFile(parser *directive.Parser, tracker *watch.Tracker, path string) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	directives, err := parser.Parse(code)
+	if err != nil {
+		return
+	}
+	tracker.Seed(path, directives)
+}